@@ -0,0 +1,232 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// adminsTable prefixes the composite keys adminsTable\x00fingerprint holding the certificates of the administrators
+const adminsTable = "Admins"
+
+// legacyAdminKey holds the single administrator of deployments predating the admin set
+const legacyAdminKey = "admin"
+
+// adminEntry is an element of the list returned by list_admins
+type adminEntry struct {
+	Fingerprint string `json:"fingerprint"` // hex SHA-256 of the certificate
+	Certificate []byte `json:"certificate"` // DER certificate, base64 encoded by encoding/json
+}
+
+// fingerprint returns the hex SHA-256 of cert
+func fingerprint(cert []byte) string {
+	hash := sha256.Sum256(cert)
+	return hex.EncodeToString(hash[:])
+}
+
+// adminKey is the state key of the administrator with the given certificate
+func adminKey(cert []byte) string {
+	return compositeKey(adminsTable, fingerprint(cert))
+}
+
+// getAdmins returns the administrators. Deployments that never changed their administrator
+// have the single one set at deploy time under the legacy key.
+func getAdmins(stub compat.Stub) ([]adminEntry, error) {
+	startKey := compositeKey(adminsTable, "")
+	iter, err := stub.RangeQueryState(startKey, startKey+"\xff")
+	if err != nil {
+		return nil, fmt.Errorf("Failed querying administrators: [%s]", err)
+	}
+	defer iter.Close()
+
+	admins := []adminEntry{}
+	for iter.HasNext() {
+		_, cert, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed iterating administrators: [%s]", err)
+		}
+		admins = append(admins, adminEntry{Fingerprint: fingerprint(cert), Certificate: cert})
+	}
+	if len(admins) != 0 {
+		return admins, nil
+	}
+
+	cert, err := stub.GetState(legacyAdminKey)
+	if err != nil {
+		return nil, errors.New("Failed fetching admin identity")
+	}
+	if len(cert) != 0 {
+		admins = append(admins, adminEntry{Fingerprint: fingerprint(cert), Certificate: cert})
+	}
+	return admins, nil
+}
+
+// putAdmin adds cert to the administrators
+func putAdmin(stub compat.Stub, cert []byte) error {
+	err := stub.PutState(adminKey(cert), cert)
+	if err != nil {
+		return fmt.Errorf("Failed storing administrator: [%s]", err)
+	}
+	return nil
+}
+
+// migrateLegacyAdmin moves the administrator stored under the legacy key into the admin set,
+// before the set is changed for the first time
+func migrateLegacyAdmin(stub compat.Stub) error {
+	cert, err := stub.GetState(legacyAdminKey)
+	if err != nil {
+		return errors.New("Failed fetching admin identity")
+	}
+	if len(cert) == 0 {
+		return nil
+	}
+
+	err = putAdmin(stub, cert)
+	if err != nil {
+		return err
+	}
+	return stub.DelState(legacyAdminKey)
+}
+
+// callerAdmin returns the certificate of the administrator the caller is
+func (t *AssetManagementChaincode) callerAdmin(stub compat.Stub) ([]byte, error) {
+	admins, err := getAdmins(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, admin := range admins {
+		ok, err := t.isCaller(stub, admin.Certificate)
+		if err != nil {
+			myLogger.Debugf("Failed checking admin identity [%s]: [%s]", admin.Fingerprint, err)
+			continue
+		}
+		if ok {
+			return admin.Certificate, nil
+		}
+	}
+	return nil, errors.New("The caller is not an administrator")
+}
+
+// checkAdmin verifies that the caller is one of the administrators
+func (t *AssetManagementChaincode) checkAdmin(stub compat.Stub) error {
+	_, err := t.callerAdmin(stub)
+	return err
+}
+
+// addAdmin adds an administrator. Only an administrator can call this function.
+func (t *AssetManagementChaincode) addAdmin(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Add admin...")
+
+	cert := args.Bytes("admin")
+	if len(cert) == 0 {
+		return nil, errors.New("Invalid admin certificate. Empty.")
+	}
+
+	err := t.checkAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrateLegacyAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := stub.GetState(adminKey(cert))
+	if err != nil {
+		return nil, errors.New("Failed fetching admin identity")
+	}
+	if existing != nil {
+		return nil, errors.New("The certificate is already an administrator")
+	}
+
+	err = putAdmin(stub, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	myLogger.Debugf("Add admin...done, [%s] is an administrator", fingerprint(cert))
+
+	return nil, nil
+}
+
+// removeAdmin removes an administrator, as long as another one remains. Only an administrator can call this function.
+func (t *AssetManagementChaincode) removeAdmin(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Remove admin...")
+
+	cert := args.Bytes("admin")
+
+	err := t.checkAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrateLegacyAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	admins, err := getAdmins(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for _, admin := range admins {
+		if admin.Fingerprint == fingerprint(cert) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errors.New("The certificate is not an administrator")
+	}
+	if len(admins) == 1 {
+		return nil, errors.New("Can't remove the last administrator")
+	}
+
+	err = stub.DelState(adminKey(cert))
+	if err != nil {
+		return nil, fmt.Errorf("Failed removing administrator: [%s]", err)
+	}
+
+	myLogger.Debugf("Remove admin...done, [%s] is no longer an administrator", fingerprint(cert))
+
+	return nil, nil
+}
+
+// listAdmins returns the administrators as JSON. Only an administrator can call this function.
+func (t *AssetManagementChaincode) listAdmins(stub compat.Stub, args *router.Args) ([]byte, error) {
+	err := t.checkAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	admins, err := getAdmins(stub)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(admins)
+}
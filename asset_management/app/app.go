@@ -131,10 +131,16 @@ func assignOwnership() (err error) {
 		res = theOwnerIs.Msg
 	}
 
-	if !reflect.DeepEqual(res, charlieCert.GetCertificate()) {
+	owner, err := ownerOf(res)
+	if err != nil {
+		appLogger.Errorf("Failed decoding result [%s]", err)
+		return
+	}
+
+	if !reflect.DeepEqual(owner, charlieCert.GetCertificate()) {
 		appLogger.Error("Charlie is not the owner.")
 
-		appLogger.Debugf("Query result  : [% x]", res)
+		appLogger.Debugf("Query result  : [% x]", owner)
 		appLogger.Debugf("Charlie's cert: [% x]", charlieCert.GetCertificate())
 
 		return fmt.Errorf("Charlie is not the owner.")
@@ -193,10 +199,16 @@ func transferOwnership() (err error) {
 		res = theOwnerIs.Msg
 	}
 
-	if !reflect.DeepEqual(res, daveCert.GetCertificate()) {
+	owner, err := ownerOf(res)
+	if err != nil {
+		appLogger.Errorf("Failed decoding result [%s]", err)
+		return
+	}
+
+	if !reflect.DeepEqual(owner, daveCert.GetCertificate()) {
 		appLogger.Error("Dave is not the owner.")
 
-		appLogger.Debugf("Query result  : [% x]", res)
+		appLogger.Debugf("Query result  : [% x]", owner)
 		appLogger.Debugf("Dave's cert: [% x]", daveCert.GetCertificate())
 
 		return fmt.Errorf("Dave is not the owner.")
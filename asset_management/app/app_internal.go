@@ -18,6 +18,7 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -295,6 +296,23 @@ func whoIsTheOwner(invoker crypto.Client, asset string) (transaction *pb.Transac
 	return
 }
 
+// ownerOf returns the certificate of the owner from the result of a query
+func ownerOf(result []byte) ([]byte, error) {
+	var status struct {
+		Owner  []byte `json:"owner"`
+		Frozen bool   `json:"frozen"`
+		Reason string `json:"reason"`
+	}
+	err := json.Unmarshal(result, &status)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decoding query result: [%s]", err)
+	}
+	if status.Frozen {
+		appLogger.Debugf("The asset is frozen [%s]", status.Reason)
+	}
+	return status.Owner, nil
+}
+
 func getChaincodeBytes(spec *pb.ChaincodeSpec) (*pb.ChaincodeDeploymentSpec, error) {
 	mode := viper.GetString("chaincode.mode")
 	var codePackageBytes []byte
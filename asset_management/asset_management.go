@@ -17,13 +17,14 @@ limitations under the License.
 package main
 
 import (
-	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/op/go-logging"
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
 )
 
 var myLogger = logging.MustGetLogger("asset_mgm")
@@ -34,25 +35,18 @@ var myLogger = logging.MustGetLogger("asset_mgm")
 // https://github.com/hyperledger/fabric/blob/master/docs/tech/application-ACL.md
 // An asset is simply represented by a string.
 type AssetManagementChaincode struct {
+	once   sync.Once
+	routes *router.Router
 }
 
 // Init method will be called during deployment.
-// The deploy transaction metadata is supposed to contain the administrator cert
-func (t *AssetManagementChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+// The deploy transaction metadata is supposed to contain the certificate of the first administrator
+func (t *AssetManagementChaincode) Init(stub compat.Stub, function string, args []string) ([]byte, error) {
 	myLogger.Debug("Init Chaincode...")
 	if len(args) != 0 {
 		return nil, errors.New("Incorrect number of arguments. Expecting 0")
 	}
 
-	// Create ownership table
-	err := stub.CreateTable("AssetsOwnership", []*shim.ColumnDefinition{
-		&shim.ColumnDefinition{Name: "Asset", Type: shim.ColumnDefinition_STRING, Key: true},
-		&shim.ColumnDefinition{Name: "Owner", Type: shim.ColumnDefinition_BYTES, Key: false},
-	})
-	if err != nil {
-		return nil, errors.New("Failed creating AssetsOnwership table.")
-	}
-
 	// Set the admin
 	// The metadata will contain the certificate of the administrator
 	adminCert, err := stub.GetCallerMetadata()
@@ -67,117 +61,112 @@ func (t *AssetManagementChaincode) Init(stub shim.ChaincodeStubInterface, functi
 
 	myLogger.Debug("The administrator is [%x]", adminCert)
 
-	stub.PutState("admin", adminCert)
+	err = putAdmin(stub, adminCert)
+	if err != nil {
+		return nil, err
+	}
 
 	myLogger.Debug("Init Chaincode...done")
 
 	return nil, nil
 }
 
-func (t *AssetManagementChaincode) assign(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func (t *AssetManagementChaincode) assign(stub compat.Stub, args *router.Args) ([]byte, error) {
 	myLogger.Debug("Assign...")
 
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2")
-	}
+	asset := args.String("asset")
+	owner := args.Bytes("owner")
 
-	asset := args[0]
-	owner, err := base64.StdEncoding.DecodeString(args[1])
+	err := validateAsset(asset)
 	if err != nil {
-		return nil, errors.New("Failed decodinf owner")
+		return nil, err
 	}
 
 	// Verify the identity of the caller
-	// Only an administrator can invoker assign
-	adminCertificate, err := stub.GetState("admin")
+	// Only an administrator can invoker assign, any of them
+	adminCert, err := t.callerAdmin(stub)
 	if err != nil {
-		return nil, errors.New("Failed fetching admin identity")
+		return nil, err
 	}
 
-	ok, err := t.isCaller(stub, adminCertificate)
+	// Register assignment
+	myLogger.Debugf("New owner of [%s] is [% x]", asset, owner)
+
+	record, err := getAsset(stub, asset)
 	if err != nil {
-		return nil, errors.New("Failed checking admin identity")
+		return nil, err
 	}
-	if !ok {
-		return nil, errors.New("The caller is not an administrator")
+	if record != nil {
+		return nil, errors.New("Asset was already assigned.")
 	}
 
-	// Register assignment
-	myLogger.Debugf("New owner of [%s] is [% x]", asset, owner)
+	err = checkNotRevoked(stub, asset)
+	if err != nil {
+		return nil, err
+	}
 
-	ok, err = stub.InsertRow("AssetsOwnership", shim.Row{
-		Columns: []*shim.Column{
-			&shim.Column{Value: &shim.Column_String_{String_: asset}},
-			&shim.Column{Value: &shim.Column_Bytes{Bytes: owner}}},
-	})
+	err = setOwner(stub, asset, nil, owner)
+	if err != nil {
+		return nil, err
+	}
 
-	if !ok && err == nil {
-		return nil, errors.New("Asset was already assigned.")
+	err = appendCustody(stub, asset, assignOp, adminCert, owner, nil)
+	if err != nil {
+		return nil, err
 	}
 
 	myLogger.Debug("Assign...done!")
 
-	return nil, err
+	return nil, nil
 }
 
-func (t *AssetManagementChaincode) transfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func (t *AssetManagementChaincode) transfer(stub compat.Stub, args *router.Args) ([]byte, error) {
 	myLogger.Debug("Transfer...")
 
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2")
-	}
+	asset := args.String("asset")
+	newOwner := args.Bytes("newOwner")
 
-	asset := args[0]
-	newOwner, err := base64.StdEncoding.DecodeString(args[1])
+	// Verify the identity of the caller
+	// Only the owner, or an operator approved by the owner, can transfer one of his assets
+	record, err := getAsset(stub, asset)
 	if err != nil {
-		return nil, fmt.Errorf("Failed decoding owner")
+		return nil, err
+	}
+	if record == nil || len(record.Owner) == 0 {
+		return nil, fmt.Errorf("Invalid previous owner. Nil")
 	}
 
-	// Verify the identity of the caller
-	// Only the owner can transfer one of his assets
-	var columns []shim.Column
-	col1 := shim.Column{Value: &shim.Column_String_{String_: asset}}
-	columns = append(columns, col1)
-
-	row, err := stub.GetRow("AssetsOwnership", columns)
+	err = checkNotFrozen(asset, record)
 	if err != nil {
-		return nil, fmt.Errorf("Failed retrieving asset [%s]: [%s]", asset, err)
+		return nil, err
 	}
 
-	prvOwner := row.Columns[1].GetBytes()
+	prvOwner := record.Owner
 	myLogger.Debugf("Previous owener of [%s] is [% x]", asset, prvOwner)
-	if len(prvOwner) == 0 {
-		return nil, fmt.Errorf("Invalid previous owner. Nil")
-	}
 
-	// Verify ownership
+	// Verify ownership, or else approval
 	ok, err := t.isCaller(stub, prvOwner)
 	if err != nil {
 		return nil, errors.New("Failed checking asset owner identity")
 	}
+	var operator []byte
 	if !ok {
-		return nil, errors.New("The caller is not the owner of the asset")
+		operator, err = t.callerOperator(stub, asset, record)
+		if err != nil {
+			return nil, err
+		}
+		myLogger.Debugf("Operator of [%s] is [%s]", asset, fingerprint(operator))
 	}
 
 	// At this point, the proof of ownership is valid, then register transfer
-	err = stub.DeleteRow(
-		"AssetsOwnership",
-		[]shim.Column{shim.Column{Value: &shim.Column_String_{String_: asset}}},
-	)
+	err = setOwner(stub, asset, record, newOwner)
 	if err != nil {
-		return nil, errors.New("Failed deliting row.")
+		return nil, err
 	}
 
-	_, err = stub.InsertRow(
-		"AssetsOwnership",
-		shim.Row{
-			Columns: []*shim.Column{
-				&shim.Column{Value: &shim.Column_String_{String_: asset}},
-				&shim.Column{Value: &shim.Column_Bytes{Bytes: newOwner}},
-			},
-		})
+	err = appendCustody(stub, asset, transferOp, prvOwner, newOwner, operator)
 	if err != nil {
-		return nil, errors.New("Failed inserting row.")
+		return nil, err
 	}
 
 	myLogger.Debug("New owner of [%s] is [% x]", asset, newOwner)
@@ -187,7 +176,7 @@ func (t *AssetManagementChaincode) transfer(stub shim.ChaincodeStubInterface, ar
 	return nil, nil
 }
 
-func (t *AssetManagementChaincode) isCaller(stub shim.ChaincodeStubInterface, certificate []byte) (bool, error) {
+func (t *AssetManagementChaincode) isCaller(stub compat.Stub, certificate []byte) (bool, error) {
 	myLogger.Debug("Check caller...")
 
 	// In order to enforce access control, we require that the
@@ -235,68 +224,232 @@ func (t *AssetManagementChaincode) isCaller(stub shim.ChaincodeStubInterface, ce
 	return ok, err
 }
 
+// functions returns the registry of the functions callable through Invoke and Query
+func (t *AssetManagementChaincode) functions() *router.Router {
+	t.once.Do(func() {
+		t.routes = router.New().
+			Invoke(router.Function{
+				Name:        "assign",
+				Description: "Assigns the ownership of asset to owner. Only an administrator can call this function",
+				Args:        []router.Arg{{Name: "asset"}, {Name: "owner", Type: router.Base64}},
+				Handler:     t.assign,
+			}).
+			Invoke(router.Function{
+				Name:        "transfer",
+				Description: "Transfers the ownership of asset to newOwner. Only the owner of asset or an operator it approved can call this function",
+				Args:        []router.Arg{{Name: "asset"}, {Name: "newOwner", Type: router.Base64}},
+				Handler:     t.transfer,
+			}).
+			Invoke(router.Function{
+				Name:        "offer_transfer",
+				Description: "Offers asset to newOwner, who becomes its owner by accepting the offer. Only the owner of asset can call this function",
+				Args:        []router.Arg{{Name: "asset"}, {Name: "newOwner", Type: router.Base64}},
+				Handler:     t.offerTransfer,
+			}).
+			Invoke(router.Function{
+				Name:        "accept_transfer",
+				Description: "Accepts the pending offer of asset. Only the recipient of the offer can call this function",
+				Args:        []router.Arg{{Name: "asset"}},
+				Handler:     t.acceptTransfer,
+			}).
+			Invoke(router.Function{
+				Name:        "reject_transfer",
+				Description: "Rejects the pending offer of asset. Only the recipient of the offer can call this function",
+				Args:        []router.Arg{{Name: "asset"}},
+				Handler:     t.rejectTransfer,
+			}).
+			Invoke(router.Function{
+				Name:        "cancel_offer",
+				Description: "Withdraws the pending offer of asset. Only the owner of asset can call this function",
+				Args:        []router.Arg{{Name: "asset"}},
+				Handler:     t.cancelOffer,
+			}).
+			Invoke(router.Function{
+				Name:        "approve_operator",
+				Description: "Lets operator transfer asset until it changes owner. Only the owner of asset can call this function",
+				Args:        []router.Arg{{Name: "asset"}, {Name: "operator", Type: router.Base64}},
+				Handler:     t.approveOperator,
+			}).
+			Invoke(router.Function{
+				Name:        "revoke_operator",
+				Description: "Revokes the approval of operator for asset. Only the owner of asset can call this function",
+				Args:        []router.Arg{{Name: "asset"}, {Name: "operator", Type: router.Base64}},
+				Handler:     t.revokeOperator,
+			}).
+			Invoke(router.Function{
+				Name:        "approve_all",
+				Description: "Lets operator transfer all the assets of owner. Only the holder of owner can call this function",
+				Args:        []router.Arg{{Name: "owner", Type: router.Base64}, {Name: "operator", Type: router.Base64}},
+				Handler:     t.approveAll,
+			}).
+			Invoke(router.Function{
+				Name:        "revoke_all",
+				Description: "Revokes the approval of operator for all the assets of owner. Only the holder of owner can call this function",
+				Args:        []router.Arg{{Name: "owner", Type: router.Base64}, {Name: "operator", Type: router.Base64}},
+				Handler:     t.revokeAll,
+			}).
+			Invoke(router.Function{
+				Name:        "freeze",
+				Description: "Blocks every change of owner of asset until it is unfrozen. Only an administrator can call this function",
+				Args:        []router.Arg{{Name: "asset"}, {Name: "reason"}},
+				Handler:     t.freeze,
+			}).
+			Invoke(router.Function{
+				Name:        "unfreeze",
+				Description: "Lets asset change owner again. Only an administrator can call this function",
+				Args:        []router.Arg{{Name: "asset"}},
+				Handler:     t.unfreeze,
+			}).
+			Invoke(router.Function{
+				Name:        "revoke",
+				Description: "Deletes the ownership of asset, recording why. Only an administrator can call this function",
+				Args:        []router.Arg{{Name: "asset"}, {Name: "reason"}},
+				Handler:     t.revoke,
+			}).
+			Invoke(router.Function{
+				Name:        "reassign",
+				Description: "Assigns a revoked asset to owner, naming the transaction that revoked it. Only an administrator can call this function",
+				Args:        []router.Arg{{Name: "asset"}, {Name: "owner", Type: router.Base64}, {Name: "revocationTxID"}},
+				Handler:     t.reassign,
+			}).
+			Invoke(router.Function{
+				Name:        "migrate_tables",
+				Description: "Copies the AssetsOwnership table of a previous deployment into the key/value state. Only an administrator can call this function",
+				Handler:     t.migrateTables,
+			}).
+			Invoke(router.Function{
+				Name:        "add_admin",
+				Description: "Makes the owner of a certificate an administrator. Only an administrator can call this function",
+				Args:        []router.Arg{{Name: "admin", Type: router.Base64}},
+				Handler:     t.addAdmin,
+			}).
+			Invoke(router.Function{
+				Name:        "remove_admin",
+				Description: "Removes an administrator, as long as another one remains. Only an administrator can call this function",
+				Args:        []router.Arg{{Name: "admin", Type: router.Base64}},
+				Handler:     t.removeAdmin,
+			}).
+			Query(router.Function{
+				Name:        "query",
+				Description: "Returns the owner of asset and whether it is frozen",
+				Args:        []router.Arg{{Name: "asset"}},
+				Handler:     t.query,
+			}).
+			Query(router.Function{
+				Name:        "list_admins",
+				Description: "Returns the certificates of the administrators. Only an administrator can call this function",
+				Handler:     t.listAdmins,
+			}).
+			Query(router.Function{
+				Name:        "assets_of",
+				Description: "Returns the assets owned by the owner of a certificate, in pages of at most limit assets",
+				Args: []router.Arg{
+					{Name: "owner", Type: router.Base64},
+					{Name: "limit", Type: router.Int, Optional: true},
+					{Name: "token", Optional: true},
+				},
+				Handler: t.assetsOf,
+			}).
+			Query(router.Function{
+				Name:        "provenance",
+				Description: "Returns the chain of custody of asset, oldest first",
+				Args:        []router.Arg{{Name: "asset"}},
+				Handler:     t.provenance,
+			}).
+			Query(router.Function{
+				Name:        "pending_offers",
+				Description: "Returns the assets offered to the holder of a certificate",
+				Args:        []router.Arg{{Name: "recipient", Type: router.Base64}},
+				Handler:     t.pendingOffers,
+			}).
+			Query(router.Function{
+				Name:        "operators_of",
+				Description: "Returns the operators who can transfer asset",
+				Args:        []router.Arg{{Name: "asset"}},
+				Handler:     t.operatorsOf,
+			}).
+			Query(router.Function{
+				Name:        "revocation",
+				Description: "Returns who revoked asset, when and why, and who reassigned it, if it was ever revoked",
+				Args:        []router.Arg{{Name: "asset"}},
+				Handler:     t.revocation,
+			})
+	})
+	return t.routes
+}
+
 // Invoke will be called for every transaction.
 // Supported functions are the following:
 // "assign(asset, owner)": to assign ownership of assets. An asset can be owned by a single entity.
 // Only an administrator can call this function.
 // "transfer(asset, newOwner)": to transfer the ownership of an asset. Only the owner of the specific
-// asset can call this function.
+// asset, or an operator approved by the owner, can call this function.
+// "offer_transfer(asset, newOwner)" and "cancel_offer(asset)": to offer an asset to a new owner and to
+// withdraw the offer. Only the owner of the specific asset can call these functions.
+// "accept_transfer(asset)" and "reject_transfer(asset)": to take or decline the ownership of an offered
+// asset. Only the recipient of the offer can call these functions.
+// "approve_operator(asset, operator)" and "revoke_operator(asset, operator)": to let an operator transfer
+// an asset until it changes owner, and to stop it. Only the owner of the specific asset can call these functions.
+// "approve_all(owner, operator)" and "revoke_all(owner, operator)": to let an operator transfer all the
+// assets of owner, and to stop it. Only the holder of the owner certificate can call these functions.
+// "add_admin(admin)" and "remove_admin(admin)": to change the administrators, at least one remaining.
+// Only an administrator can call these functions.
+// "freeze(asset, reason)" and "unfreeze(asset)": to block and allow again the changes of owner of an asset.
+// Only an administrator can call these functions.
+// "revoke(asset, reason)" and "reassign(asset, owner, revocationTxID)": to delete the ownership of an asset,
+// and to assign it again afterwards. Only an administrator can call these functions.
+// "migrate_tables()": to copy the ownership table of a previous deployment into the key/value state.
+// Only an administrator can call this function.
 // An asset is any string to identify it. An owner is representated by one of his ECert/TCert.
-func (t *AssetManagementChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-
-	// Handle different functions
-	if function == "assign" {
-		// Assign ownership
-		return t.assign(stub, args)
-	} else if function == "transfer" {
-		// Transfer ownership
-		return t.transfer(stub, args)
-	}
-
-	return nil, errors.New("Received unknown function invocation")
+func (t *AssetManagementChaincode) Invoke(stub compat.Stub, function string, args []string) ([]byte, error) {
+	return t.functions().HandleInvoke(stub, function, args)
 }
 
 // Query callback representing the query of a chaincode
 // Supported functions are the following:
-// "query(asset)": returns the owner of the asset.
+// "query(asset)": returns the owner of the asset, and whether it is frozen and why, as JSON.
 // Anyone can invoke this function.
-func (t *AssetManagementChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+// "list_admins()": returns the administrators. Only an administrator can invoke this function.
+// "assets_of(owner, [limit], [token])": returns the assets of owner. Anyone can invoke this function.
+// "provenance(asset)": returns the chain of custody of the asset. Anyone can invoke this function.
+// "pending_offers(recipient)": returns the assets offered to recipient. Anyone can invoke this function.
+// "operators_of(asset)": returns the operators who can transfer the asset. Anyone can invoke this function.
+// "revocation(asset)": returns the last revocation of the asset, if it was ever revoked. Anyone can invoke this function.
+func (t *AssetManagementChaincode) Query(stub compat.Stub, function string, args []string) ([]byte, error) {
 	myLogger.Debugf("Query [%s]", function)
 
-	if function != "query" {
-		return nil, errors.New("Invalid query function name. Expecting 'query' but found '" + function + "'")
-	}
-
-	var err error
+	return t.functions().HandleQuery(stub, function, args)
+}
 
-	if len(args) != 1 {
-		myLogger.Debug("Incorrect number of arguments. Expecting name of an asset to query")
-		return nil, errors.New("Incorrect number of arguments. Expecting name of an asset to query")
-	}
+// IsQuery tells whether function is a query, for Fabric versions where queries go through Invoke
+func (t *AssetManagementChaincode) IsQuery(function string) bool {
+	return t.functions().IsQuery(function)
+}
 
+func (t *AssetManagementChaincode) query(stub compat.Stub, args *router.Args) ([]byte, error) {
 	// Who is the owner of the asset?
-	asset := args[0]
+	asset := args.String("asset")
 
 	myLogger.Debugf("Arg [%s]", string(asset))
 
-	var columns []shim.Column
-	col1 := shim.Column{Value: &shim.Column_String_{String_: asset}}
-	columns = append(columns, col1)
-
-	row, err := stub.GetRow("AssetsOwnership", columns)
+	record, err := getAsset(stub, asset)
 	if err != nil {
 		myLogger.Debugf("Failed retriving asset [%s]: [%s]", string(asset), err)
 		return nil, fmt.Errorf("Failed retriving asset [%s]: [%s]", string(asset), err)
 	}
+	if record == nil {
+		myLogger.Debugf("Asset [%s] not assigned", string(asset))
+		return nil, nil
+	}
 
-	myLogger.Debugf("Query done [% x]", row.Columns[1].GetBytes())
+	myLogger.Debugf("Query done [% x]", record.Owner)
 
-	return row.Columns[1].GetBytes(), nil
+	return json.Marshal(assetStatus{Owner: record.Owner, Frozen: record.Frozen, Reason: record.Reason})
 }
 
 func main() {
-	primitives.SetSecurityLevel("SHA3", 256)
-	err := shim.Start(new(AssetManagementChaincode))
+	setSecurityLevel()
+	err := compat.Start(new(AssetManagementChaincode))
 	if err != nil {
 		fmt.Printf("Error starting AssetManagementChaincode: %s", err)
 	}
@@ -0,0 +1,476 @@
+//go:build !fabric1
+// +build !fabric1
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shiliy/learn-chaincode/compat"
+)
+
+// callerStub is a mock stub whose caller signs the transactions with the certificate named by the test:
+// the signature of a certificate is the certificate itself. rows stands for the AssetsOwnership table.
+type callerStub struct {
+	*shim.MockStub
+	signer []byte
+	rows   []shim.Row
+}
+
+// newCallerStub deploys the chaincode with admin as the first administrator
+func newCallerStub(t *testing.T, cc *AssetManagementChaincode, admin string) *callerStub {
+	stub := &callerStub{MockStub: shim.NewMockStub("asset_management", compat.Wrap(cc)), signer: []byte(admin)}
+
+	stub.MockTransactionStart("deploy")
+	_, err := cc.Init(stub, "init", nil)
+	stub.MockTransactionEnd("deploy")
+	if err != nil {
+		t.Fatalf("init failed: %s", err)
+	}
+	return stub
+}
+
+func (s *callerStub) GetCallerMetadata() ([]byte, error) {
+	return s.signer, nil
+}
+
+func (s *callerStub) GetPayload() ([]byte, error) {
+	return nil, nil
+}
+
+func (s *callerStub) GetBinding() ([]byte, error) {
+	return nil, nil
+}
+
+func (s *callerStub) VerifySignature(certificate, signature, message []byte) (bool, error) {
+	return bytes.Equal(certificate, signature), nil
+}
+
+func (s *callerStub) GetRows(tableName string, key []shim.Column) (<-chan shim.Row, error) {
+	rows := make(chan shim.Row, len(s.rows))
+	for _, row := range s.rows {
+		rows <- row
+	}
+	close(rows)
+	return rows, nil
+}
+
+func (s *callerStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: 1480000000}, nil
+}
+
+// invoke runs function as transaction txID signed by caller
+func (s *callerStub) invoke(cc *AssetManagementChaincode, caller, txID, function string, args ...string) error {
+	s.signer = []byte(caller)
+	s.MockTransactionStart(txID)
+	defer s.MockTransactionEnd(txID)
+
+	_, err := cc.Invoke(s, function, args)
+	return err
+}
+
+// mustInvoke runs function as transaction txID signed by caller and fails the test if it fails
+func (s *callerStub) mustInvoke(t *testing.T, cc *AssetManagementChaincode, caller, txID, function string, args ...string) {
+	if err := s.invoke(cc, caller, txID, function, args...); err != nil {
+		t.Fatalf("%s failed: %s", function, err)
+	}
+}
+
+// query runs function and decodes its JSON result into result
+func (s *callerStub) query(t *testing.T, cc *AssetManagementChaincode, result interface{}, function string, args ...string) {
+	resultAsBytes, err := cc.Query(s, function, args)
+	if err != nil {
+		t.Fatalf("%s failed: %s", function, err)
+	}
+	if err := json.Unmarshal(resultAsBytes, result); err != nil {
+		t.Fatalf("Invalid %s result [%s]: %s", function, string(resultAsBytes), err)
+	}
+}
+
+// ownerOf returns the name of the certificate owning asset, or an empty string if it is not assigned
+func (s *callerStub) ownerOf(t *testing.T, cc *AssetManagementChaincode, asset string) string {
+	statusAsBytes, err := cc.Query(s, "query", []string{asset})
+	if err != nil {
+		t.Fatalf("query %s failed: %s", asset, err)
+	}
+	if statusAsBytes == nil {
+		return ""
+	}
+
+	var status assetStatus
+	if err := json.Unmarshal(statusAsBytes, &status); err != nil {
+		t.Fatalf("Invalid query result [%s]: %s", string(statusAsBytes), err)
+	}
+	return string(status.Owner)
+}
+
+// cert returns the base64 encoding of the certificate named name, as passed to the functions
+func cert(name string) string {
+	return base64.StdEncoding.EncodeToString([]byte(name))
+}
+
+// expectError fails the test unless err holds message
+func expectError(t *testing.T, function string, err error, message string) {
+	if err == nil || !strings.Contains(err.Error(), message) {
+		t.Fatalf("%s returned [%v], expected an error holding [%s]", function, err, message)
+	}
+}
+
+// TestMigrateTables checks that the rows of the ownership table are copied once, without overriding the assets
+// assigned since
+func TestMigrateTables(t *testing.T) {
+	cc := new(AssetManagementChaincode)
+	stub := newCallerStub(t, cc, "admin")
+	stub.rows = []shim.Row{
+		{Columns: []*shim.Column{{Value: &shim.Column_String_{String_: "Picasso"}}, {Value: &shim.Column_Bytes{Bytes: []byte("carol")}}}},
+		{Columns: []*shim.Column{{Value: &shim.Column_String_{String_: "Monet"}}, {Value: &shim.Column_Bytes{Bytes: []byte("alice")}}}},
+	}
+
+	stub.mustInvoke(t, cc, "admin", "tx1", "assign", "Picasso", cert("bob"))
+
+	err := stub.invoke(cc, "alice", "tx2", "migrate_tables")
+	expectError(t, "migrate_tables", err, "not an administrator")
+
+	for _, expected := range []string{"1", "0"} {
+		stub.signer = []byte("admin")
+		stub.MockTransactionStart("tx3")
+		count, err := cc.Invoke(stub, "migrate_tables", nil)
+		stub.MockTransactionEnd("tx3")
+		if err != nil || string(count) != expected {
+			t.Fatalf("migrate_tables returned [%s] [%v], expected %s", count, err, expected)
+		}
+	}
+
+	if owner := stub.ownerOf(t, cc, "Monet"); owner != "alice" {
+		t.Fatalf("Monet is owned by [%s], expected alice", owner)
+	}
+	if owner := stub.ownerOf(t, cc, "Picasso"); owner != "bob" {
+		t.Fatalf("Picasso is owned by [%s], expected bob", owner)
+	}
+
+	// The migrated owner holds the asset as if it was assigned
+	stub.mustInvoke(t, cc, "alice", "tx4", "transfer", "Monet", cert("carol"))
+	if owner := stub.ownerOf(t, cc, "Monet"); owner != "carol" {
+		t.Fatalf("Monet is owned by [%s], expected carol", owner)
+	}
+}
+
+// TestRevokeReassign checks that a revoked asset can only be reassigned, and that its revocation is kept
+func TestRevokeReassign(t *testing.T) {
+	cc := new(AssetManagementChaincode)
+	stub := newCallerStub(t, cc, "admin")
+
+	stub.mustInvoke(t, cc, "admin", "tx1", "assign", "Picasso", cert("alice"))
+	stub.mustInvoke(t, cc, "admin", "tx2", "revoke", "Picasso", "stolen")
+
+	err := stub.invoke(cc, "admin", "tx3", "assign", "Picasso", cert("bob"))
+	expectError(t, "assign", err, "was revoked by transaction [tx2]")
+
+	err = stub.invoke(cc, "admin", "tx3", "reassign", "Picasso", cert("bob"), "tx1")
+	expectError(t, "reassign", err, "not [tx1]")
+
+	stub.mustInvoke(t, cc, "admin", "tx4", "reassign", "Picasso", cert("bob"), "tx2")
+
+	var revocation revocationRecord
+	stub.query(t, cc, &revocation, "revocation", "Picasso")
+	expected := revocationRecord{
+		Admin:               fingerprint([]byte("admin")),
+		Owner:               fingerprint([]byte("alice")),
+		Reason:              "stolen",
+		TxID:                "tx2",
+		Timestamp:           "2016-11-24T15:06:40Z",
+		ReassignedBy:        fingerprint([]byte("admin")),
+		ReassignedTxID:      "tx4",
+		ReassignedTimestamp: "2016-11-24T15:06:40Z",
+	}
+	if revocation != expected {
+		t.Fatalf("Unexpected revocation %+v, expected %+v", revocation, expected)
+	}
+
+	if owner := stub.ownerOf(t, cc, "Picasso"); owner != "bob" {
+		t.Fatalf("Picasso is owned by [%s], expected bob", owner)
+	}
+
+	err = stub.invoke(cc, "admin", "tx5", "reassign", "Picasso", cert("carol"), "tx2")
+	expectError(t, "reassign", err, "already reassigned by transaction [tx4]")
+}
+
+// TestAdmins checks that administrators can hand over the role, but never remove the last of them
+func TestAdmins(t *testing.T) {
+	cc := new(AssetManagementChaincode)
+	stub := newCallerStub(t, cc, "alice")
+
+	err := stub.invoke(cc, "bob", "tx1", "add_admin", cert("bob"))
+	expectError(t, "add_admin", err, "not an administrator")
+
+	stub.mustInvoke(t, cc, "alice", "tx2", "add_admin", cert("bob"))
+	err = stub.invoke(cc, "alice", "tx3", "add_admin", cert("bob"))
+	expectError(t, "add_admin", err, "already an administrator")
+
+	stub.mustInvoke(t, cc, "bob", "tx4", "remove_admin", cert("alice"))
+	err = stub.invoke(cc, "bob", "tx5", "remove_admin", cert("bob"))
+	expectError(t, "remove_admin", err, "Can't remove the last administrator")
+
+	err = stub.invoke(cc, "alice", "tx6", "assign", "Picasso", cert("carol"))
+	expectError(t, "assign", err, "not an administrator")
+	stub.mustInvoke(t, cc, "bob", "tx7", "assign", "Picasso", cert("carol"))
+
+	var admins []adminEntry
+	stub.signer = []byte("bob")
+	stub.query(t, cc, &admins, "list_admins")
+	if len(admins) != 1 || admins[0].Fingerprint != fingerprint([]byte("bob")) || string(admins[0].Certificate) != "bob" {
+		t.Fatalf("Unexpected administrators %+v, expected bob alone", admins)
+	}
+}
+
+// TestLegacyAdmin checks that the single administrator of older deployments keeps the role
+func TestLegacyAdmin(t *testing.T) {
+	cc := new(AssetManagementChaincode)
+	stub := &callerStub{MockStub: shim.NewMockStub("asset_management", compat.Wrap(cc))}
+
+	stub.MockTransactionStart("deploy")
+	err := stub.PutState(legacyAdminKey, []byte("alice"))
+	stub.MockTransactionEnd("deploy")
+	if err != nil {
+		t.Fatalf("Failed storing the legacy administrator: %s", err)
+	}
+
+	stub.mustInvoke(t, cc, "alice", "tx1", "add_admin", cert("bob"))
+	stub.mustInvoke(t, cc, "bob", "tx2", "remove_admin", cert("alice"))
+
+	err = stub.invoke(cc, "alice", "tx3", "assign", "Picasso", cert("carol"))
+	expectError(t, "assign", err, "not an administrator")
+
+	var admins []adminEntry
+	stub.signer = []byte("bob")
+	stub.query(t, cc, &admins, "list_admins")
+	if len(admins) != 1 || admins[0].Fingerprint != fingerprint([]byte("bob")) {
+		t.Fatalf("Unexpected administrators %+v, expected bob alone", admins)
+	}
+}
+
+// assetsOf collects the assets of owner, two per page
+func (s *callerStub) assetsOf(t *testing.T, cc *AssetManagementChaincode, owner string) []string {
+	assets := []string{}
+	args := []string{cert(owner), "2"}
+	for {
+		var page assetsPage
+		s.query(t, cc, &page, "assets_of", args...)
+		if len(page.Assets) > 2 {
+			t.Fatalf("assets_of returned %d assets, expected 2 at most", len(page.Assets))
+		}
+		assets = append(assets, page.Assets...)
+		if page.Next == "" {
+			return assets
+		}
+		args = []string{cert(owner), "2", page.Next}
+	}
+}
+
+// TestAssetsOf checks that the assets of each owner follow every change of owner
+func TestAssetsOf(t *testing.T) {
+	cc := new(AssetManagementChaincode)
+	stub := newCallerStub(t, cc, "admin")
+
+	for _, asset := range []string{"Renoir", "Monet", "Picasso"} {
+		stub.mustInvoke(t, cc, "admin", "tx-"+asset, "assign", asset, cert("alice"))
+	}
+	if assets := stub.assetsOf(t, cc, "alice"); !reflect.DeepEqual(assets, []string{"Monet", "Picasso", "Renoir"}) {
+		t.Fatalf("alice owns %v, expected Monet, Picasso and Renoir", assets)
+	}
+
+	// Such names would sort after the end of the pages of assets_of
+	err := stub.invoke(cc, "admin", "tx0", "assign", "Pic\xffasso", cert("alice"))
+	expectError(t, "assign", err, "valid UTF-8")
+
+	stub.mustInvoke(t, cc, "alice", "tx1", "transfer", "Monet", cert("bob"))
+	stub.mustInvoke(t, cc, "alice", "tx2", "offer_transfer", "Picasso", cert("carol"))
+	stub.mustInvoke(t, cc, "carol", "tx3", "accept_transfer", "Picasso")
+	stub.mustInvoke(t, cc, "admin", "tx4", "revoke", "Renoir", "stolen")
+	stub.mustInvoke(t, cc, "admin", "tx5", "reassign", "Renoir", cert("bob"), "tx4")
+
+	expected := map[string][]string{
+		"alice": {},
+		"bob":   {"Monet", "Renoir"},
+		"carol": {"Picasso"},
+	}
+	for owner, owned := range expected {
+		if assets := stub.assetsOf(t, cc, owner); !reflect.DeepEqual(assets, owned) {
+			t.Errorf("%s owns %v, expected %v", owner, assets, owned)
+		}
+	}
+}
+
+// TestOperators checks that operators approved for an asset lose their approval when it changes owner
+func TestOperators(t *testing.T) {
+	cc := new(AssetManagementChaincode)
+	stub := newCallerStub(t, cc, "admin")
+
+	stub.mustInvoke(t, cc, "admin", "tx1", "assign", "Picasso", cert("alice"))
+	stub.mustInvoke(t, cc, "admin", "tx2", "assign", "Monet", cert("alice"))
+
+	err := stub.invoke(cc, "dave", "tx3", "approve_operator", "Picasso", cert("dave"))
+	expectError(t, "approve_operator", err, "not the owner")
+
+	stub.mustInvoke(t, cc, "alice", "tx4", "approve_operator", "Picasso", cert("dave"))
+	stub.mustInvoke(t, cc, "alice", "tx5", "approve_all", cert("alice"), cert("erin"))
+
+	var operators []operatorEntry
+	stub.query(t, cc, &operators, "operators_of", "Picasso")
+	if len(operators) != 2 {
+		t.Fatalf("Picasso has operators %+v, expected dave and erin", operators)
+	}
+
+	stub.mustInvoke(t, cc, "dave", "tx6", "transfer", "Picasso", cert("bob"))
+
+	var custody []custodyEntry
+	stub.query(t, cc, &custody, "provenance", "Picasso")
+	if last := custody[len(custody)-1]; last.By != fingerprint([]byte("dave")) {
+		t.Fatalf("The transfer was recorded by [%s], expected dave", last.By)
+	}
+
+	stub.query(t, cc, &operators, "operators_of", "Picasso")
+	if len(operators) != 0 {
+		t.Fatalf("Picasso kept operators %+v after its transfer", operators)
+	}
+
+	err = stub.invoke(cc, "dave", "tx7", "transfer", "Picasso", cert("dave"))
+	expectError(t, "transfer", err, "neither the owner nor an operator")
+	err = stub.invoke(cc, "erin", "tx8", "transfer", "Picasso", cert("erin"))
+	expectError(t, "transfer", err, "neither the owner nor an operator")
+
+	// The approval for all the assets of alice still covers the ones she keeps
+	stub.mustInvoke(t, cc, "erin", "tx9", "transfer", "Monet", cert("bob"))
+	if owner := stub.ownerOf(t, cc, "Monet"); owner != "bob" {
+		t.Fatalf("Monet is owned by [%s], expected bob", owner)
+	}
+}
+
+// TestFreeze checks that a frozen asset can't change owner until it is unfrozen
+func TestFreeze(t *testing.T) {
+	cc := new(AssetManagementChaincode)
+	stub := newCallerStub(t, cc, "admin")
+
+	stub.mustInvoke(t, cc, "admin", "tx1", "assign", "Picasso", cert("alice"))
+	stub.mustInvoke(t, cc, "admin", "tx2", "assign", "Monet", cert("alice"))
+	stub.mustInvoke(t, cc, "alice", "tx3", "offer_transfer", "Picasso", cert("bob"))
+
+	err := stub.invoke(cc, "alice", "tx4", "freeze", "Picasso", "disputed")
+	expectError(t, "freeze", err, "not an administrator")
+	err = stub.invoke(cc, "admin", "tx4", "freeze", "Picasso", "")
+	expectError(t, "freeze", err, "Invalid reason")
+
+	stub.mustInvoke(t, cc, "admin", "tx5", "freeze", "Picasso", "disputed")
+	stub.mustInvoke(t, cc, "admin", "tx6", "freeze", "Monet", "disputed")
+
+	var status assetStatus
+	stub.query(t, cc, &status, "query", "Picasso")
+	if !status.Frozen || status.Reason != "disputed" {
+		t.Fatalf("Unexpected status %+v, expected Picasso frozen as disputed", status)
+	}
+
+	err = stub.invoke(cc, "alice", "tx7", "transfer", "Picasso", cert("carol"))
+	expectError(t, "transfer", err, "Asset [Picasso] is frozen: [disputed]")
+	err = stub.invoke(cc, "bob", "tx8", "accept_transfer", "Picasso")
+	expectError(t, "accept_transfer", err, "Asset [Picasso] is frozen: [disputed]")
+	err = stub.invoke(cc, "alice", "tx9", "offer_transfer", "Monet", cert("carol"))
+	expectError(t, "offer_transfer", err, "Asset [Monet] is frozen: [disputed]")
+
+	stub.mustInvoke(t, cc, "admin", "tx10", "unfreeze", "Picasso")
+	stub.mustInvoke(t, cc, "bob", "tx11", "accept_transfer", "Picasso")
+	if owner := stub.ownerOf(t, cc, "Picasso"); owner != "bob" {
+		t.Fatalf("Picasso is owned by [%s], expected bob", owner)
+	}
+}
+
+// TestOffers checks that an offer changes the owner only once its recipient accepts it
+func TestOffers(t *testing.T) {
+	cc := new(AssetManagementChaincode)
+	stub := newCallerStub(t, cc, "admin")
+
+	stub.mustInvoke(t, cc, "admin", "tx1", "assign", "Picasso", cert("alice"))
+	stub.mustInvoke(t, cc, "alice", "tx2", "offer_transfer", "Picasso", cert("bob"))
+
+	err := stub.invoke(cc, "alice", "tx3", "offer_transfer", "Picasso", cert("carol"))
+	expectError(t, "offer_transfer", err, "already has a pending offer")
+
+	var offers []pendingOffer
+	stub.query(t, cc, &offers, "pending_offers", cert("bob"))
+	if len(offers) != 1 || offers[0].Asset != "Picasso" || string(offers[0].Owner) != "alice" {
+		t.Fatalf("Unexpected offers %+v, expected Picasso from alice", offers)
+	}
+
+	err = stub.invoke(cc, "carol", "tx4", "accept_transfer", "Picasso")
+	expectError(t, "accept_transfer", err, "not the recipient")
+	stub.mustInvoke(t, cc, "bob", "tx5", "reject_transfer", "Picasso")
+	if owner := stub.ownerOf(t, cc, "Picasso"); owner != "alice" {
+		t.Fatalf("Picasso is owned by [%s], expected alice", owner)
+	}
+
+	stub.mustInvoke(t, cc, "alice", "tx6", "offer_transfer", "Picasso", cert("carol"))
+	stub.mustInvoke(t, cc, "alice", "tx7", "cancel_offer", "Picasso")
+	err = stub.invoke(cc, "carol", "tx8", "accept_transfer", "Picasso")
+	expectError(t, "accept_transfer", err, "has no pending offer")
+
+	stub.mustInvoke(t, cc, "alice", "tx9", "offer_transfer", "Picasso", cert("carol"))
+	stub.mustInvoke(t, cc, "carol", "tx10", "accept_transfer", "Picasso")
+	if owner := stub.ownerOf(t, cc, "Picasso"); owner != "carol" {
+		t.Fatalf("Picasso is owned by [%s], expected carol", owner)
+	}
+
+	stub.query(t, cc, &offers, "pending_offers", cert("carol"))
+	if len(offers) != 0 {
+		t.Fatalf("carol kept offers %+v after accepting", offers)
+	}
+}
+
+// TestProvenance checks that every change of owner is appended to the chain of custody
+func TestProvenance(t *testing.T) {
+	cc := new(AssetManagementChaincode)
+	stub := newCallerStub(t, cc, "admin")
+
+	stub.mustInvoke(t, cc, "admin", "tx1", "assign", "Picasso", cert("alice"))
+	stub.mustInvoke(t, cc, "alice", "tx2", "transfer", "Picasso", cert("bob"))
+	stub.mustInvoke(t, cc, "admin", "tx3", "revoke", "Picasso", "stolen")
+	stub.mustInvoke(t, cc, "admin", "tx4", "reassign", "Picasso", cert("carol"), "tx3")
+
+	admin, alice, bob, carol := fingerprint([]byte("admin")), fingerprint([]byte("alice")), fingerprint([]byte("bob")), fingerprint([]byte("carol"))
+	expected := []custodyEntry{
+		{Seq: 0, Op: assignOp, From: admin, To: alice, TxID: "tx1"},
+		{Seq: 1, Op: transferOp, From: alice, To: bob, TxID: "tx2"},
+		{Seq: 2, Op: revokeOp, From: bob, To: admin, Reason: "stolen", TxID: "tx3"},
+		{Seq: 3, Op: reassignOp, From: admin, To: carol, TxID: "tx4"},
+	}
+	for i := range expected {
+		expected[i].Timestamp = "2016-11-24T15:06:40Z"
+	}
+
+	var custody []custodyEntry
+	stub.query(t, cc, &custody, "provenance", "Picasso")
+	if !reflect.DeepEqual(custody, expected) {
+		t.Fatalf("Unexpected chain of custody %+v, expected %+v", custody, expected)
+	}
+}
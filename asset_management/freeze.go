@@ -0,0 +1,99 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// checkNotFrozen refuses to move a frozen asset
+func checkNotFrozen(asset string, record *assetRecord) error {
+	if record.Frozen {
+		return fmt.Errorf("Asset [%s] is frozen: [%s]", asset, record.Reason)
+	}
+	return nil
+}
+
+// freeze blocks every change of owner of asset until it is unfrozen. Only an administrator can call this function.
+func (t *AssetManagementChaincode) freeze(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Freeze...")
+
+	asset := args.String("asset")
+	reason := args.String("reason")
+	if reason == "" {
+		return nil, errors.New("Invalid reason. Empty.")
+	}
+
+	err := t.checkAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := assignedAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+	if record.Frozen {
+		return nil, fmt.Errorf("Asset [%s] is already frozen", asset)
+	}
+
+	record.Frozen = true
+	record.Reason = reason
+	err = putAsset(stub, asset, record)
+	if err != nil {
+		return nil, err
+	}
+
+	myLogger.Debugf("Freeze...done, [%s] is frozen", asset)
+
+	return nil, nil
+}
+
+// unfreeze lets asset change owner again. Only an administrator can call this function.
+func (t *AssetManagementChaincode) unfreeze(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Unfreeze...")
+
+	asset := args.String("asset")
+
+	err := t.checkAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := assignedAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+	if !record.Frozen {
+		return nil, fmt.Errorf("Asset [%s] is not frozen", asset)
+	}
+
+	record.Frozen = false
+	record.Reason = ""
+	err = putAsset(stub, asset, record)
+	if err != nil {
+		return nil, err
+	}
+
+	myLogger.Debugf("Unfreeze...done, [%s] is no longer frozen", asset)
+
+	return nil, nil
+}
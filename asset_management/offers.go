@@ -0,0 +1,251 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// pendingOffersTable prefixes the composite keys pendingOffersTable\x00fingerprint\x00asset
+// indexing the assets offered to each recipient
+const pendingOffersTable = "PendingOffers"
+
+// pendingOffer is an element of the list returned by pending_offers
+type pendingOffer struct {
+	Asset string `json:"asset"`
+	Owner []byte `json:"owner"` // DER certificate of the owner making the offer, base64 encoded by encoding/json
+}
+
+// pendingOfferKey is the state key of the index entry of the offer of asset to recipient
+func pendingOfferKey(recipient []byte, asset string) string {
+	return compositeKey(pendingOffersTable, fingerprint(recipient), asset)
+}
+
+// clearOffer withdraws the pending offer of asset, if any. The caller stores the record.
+func clearOffer(stub compat.Stub, asset string, record *assetRecord) error {
+	if len(record.Offer) == 0 {
+		return nil
+	}
+
+	err := stub.DelState(pendingOfferKey(record.Offer, asset))
+	if err != nil {
+		return fmt.Errorf("Failed removing the offer of [%s]: [%s]", asset, err)
+	}
+	record.Offer = nil
+	return nil
+}
+
+// ownedAsset returns the record of asset after verifying that the caller is its owner
+func (t *AssetManagementChaincode) ownedAsset(stub compat.Stub, asset string) (*assetRecord, error) {
+	record, err := assignedAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := t.isCaller(stub, record.Owner)
+	if err != nil {
+		return nil, errors.New("Failed checking asset owner identity")
+	}
+	if !ok {
+		return nil, errors.New("The caller is not the owner of the asset")
+	}
+	return record, nil
+}
+
+// offeredAsset returns the record of asset after verifying that the caller is the recipient of its pending offer
+func (t *AssetManagementChaincode) offeredAsset(stub compat.Stub, asset string) (*assetRecord, error) {
+	record, err := getAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || len(record.Offer) == 0 {
+		return nil, fmt.Errorf("Asset [%s] has no pending offer", asset)
+	}
+
+	ok, err := t.isCaller(stub, record.Offer)
+	if err != nil {
+		return nil, errors.New("Failed checking offer recipient identity")
+	}
+	if !ok {
+		return nil, errors.New("The caller is not the recipient of the offer")
+	}
+	return record, nil
+}
+
+// offerTransfer offers asset to newOwner, who becomes its owner by accepting the offer.
+// Only the owner can call this function.
+func (t *AssetManagementChaincode) offerTransfer(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Offer transfer...")
+
+	asset := args.String("asset")
+	newOwner := args.Bytes("newOwner")
+	if len(newOwner) == 0 {
+		return nil, errors.New("Invalid new owner certificate. Empty.")
+	}
+
+	record, err := t.ownedAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotFrozen(asset, record)
+	if err != nil {
+		return nil, err
+	}
+	if len(record.Offer) != 0 {
+		return nil, fmt.Errorf("Asset [%s] already has a pending offer. Cancel it first", asset)
+	}
+	if bytes.Equal(record.Owner, newOwner) {
+		return nil, errors.New("The new owner is already the owner of the asset")
+	}
+
+	record.Offer = newOwner
+	err = putAsset(stub, asset, record)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.PutState(pendingOfferKey(newOwner, asset), []byte(asset))
+	if err != nil {
+		return nil, fmt.Errorf("Failed storing the offer of [%s]: [%s]", asset, err)
+	}
+
+	myLogger.Debugf("Offer transfer...done, [%s] offered to [% x]", asset, newOwner)
+
+	return nil, nil
+}
+
+// acceptTransfer makes the recipient of the pending offer of asset its owner.
+// Only the recipient of the offer can call this function.
+func (t *AssetManagementChaincode) acceptTransfer(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Accept transfer...")
+
+	asset := args.String("asset")
+
+	record, err := t.offeredAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotFrozen(asset, record)
+	if err != nil {
+		return nil, err
+	}
+
+	prvOwner, newOwner := record.Owner, record.Offer
+	err = setOwner(stub, asset, record, newOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = appendCustody(stub, asset, transferOp, prvOwner, newOwner, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	myLogger.Debugf("Accept transfer...done, new owner of [%s] is [% x]", asset, newOwner)
+
+	return nil, nil
+}
+
+// rejectTransfer declines the pending offer of asset. Only the recipient of the offer can call this function.
+func (t *AssetManagementChaincode) rejectTransfer(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Reject transfer...")
+
+	asset := args.String("asset")
+
+	record, err := t.offeredAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	err = clearOffer(stub, asset, record)
+	if err != nil {
+		return nil, err
+	}
+	err = putAsset(stub, asset, record)
+	if err != nil {
+		return nil, err
+	}
+
+	myLogger.Debug("Reject transfer...done")
+
+	return nil, nil
+}
+
+// cancelOffer withdraws the pending offer of asset. Only the owner can call this function.
+func (t *AssetManagementChaincode) cancelOffer(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Cancel offer...")
+
+	asset := args.String("asset")
+
+	record, err := t.ownedAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+	if len(record.Offer) == 0 {
+		return nil, fmt.Errorf("Asset [%s] has no pending offer", asset)
+	}
+
+	err = clearOffer(stub, asset, record)
+	if err != nil {
+		return nil, err
+	}
+	err = putAsset(stub, asset, record)
+	if err != nil {
+		return nil, err
+	}
+
+	myLogger.Debug("Cancel offer...done")
+
+	return nil, nil
+}
+
+// pendingOffers returns the offers awaiting the holder of a certificate as JSON. Anyone can call this function.
+func (t *AssetManagementChaincode) pendingOffers(stub compat.Stub, args *router.Args) ([]byte, error) {
+	recipient := args.Bytes("recipient")
+
+	startKey := pendingOfferKey(recipient, "")
+	iter, err := stub.RangeQueryState(startKey, startKey+"\xff")
+	if err != nil {
+		return nil, fmt.Errorf("Failed querying offers: [%s]", err)
+	}
+	defer iter.Close()
+
+	offers := []pendingOffer{}
+	for iter.HasNext() {
+		_, asset, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed iterating offers: [%s]", err)
+		}
+
+		record, err := getAsset(stub, string(asset))
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			continue
+		}
+		offers = append(offers, pendingOffer{Asset: string(asset), Owner: record.Owner})
+	}
+
+	return json.Marshal(offers)
+}
@@ -0,0 +1,269 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// assetOperatorsTable prefixes the composite keys assetOperatorsTable\x00asset\x00fingerprint
+// holding the certificates of the operators approved for a single asset
+const assetOperatorsTable = "AssetOperators"
+
+// ownerOperatorsTable prefixes the composite keys ownerOperatorsTable\x00fingerprint\x00fingerprint
+// holding the certificates of the operators approved for all the assets of an owner
+const ownerOperatorsTable = "OwnerOperators"
+
+// Scopes of operator approvals
+const (
+	assetScope = "asset"
+	allScope   = "all"
+)
+
+// operatorEntry is an element of the list returned by operators_of
+type operatorEntry struct {
+	Fingerprint string `json:"fingerprint"` // hex SHA-256 of the certificate
+	Certificate []byte `json:"certificate"` // DER certificate, base64 encoded by encoding/json
+	Scope       string `json:"scope"`       // assetScope or allScope
+}
+
+// assetOperatorKey is the state key of the approval of operator for asset
+func assetOperatorKey(asset string, operator []byte) string {
+	return compositeKey(assetOperatorsTable, asset, fingerprint(operator))
+}
+
+// ownerOperatorKey is the state key of the approval of operator for all the assets of owner
+func ownerOperatorKey(owner, operator []byte) string {
+	return compositeKey(ownerOperatorsTable, fingerprint(owner), fingerprint(operator))
+}
+
+// getOperators returns the certificates stored under the composite keys starting with prefix
+func getOperators(stub compat.Stub, prefix string) ([][]byte, error) {
+	iter, err := stub.RangeQueryState(prefix, prefix+"\xff")
+	if err != nil {
+		return nil, fmt.Errorf("Failed querying operators: [%s]", err)
+	}
+	defer iter.Close()
+
+	operators := [][]byte{}
+	for iter.HasNext() {
+		_, cert, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed iterating operators: [%s]", err)
+		}
+		operators = append(operators, cert)
+	}
+	return operators, nil
+}
+
+// clearOperators revokes the approvals given for asset
+func clearOperators(stub compat.Stub, asset string) error {
+	operators, err := getOperators(stub, compositeKey(assetOperatorsTable, asset, ""))
+	if err != nil {
+		return err
+	}
+
+	for _, operator := range operators {
+		err = stub.DelState(assetOperatorKey(asset, operator))
+		if err != nil {
+			return fmt.Errorf("Failed revoking operator of [%s]: [%s]", asset, err)
+		}
+	}
+	return nil
+}
+
+// callerOperator returns the certificate of the operator approved for asset the caller is,
+// for asset itself or for all the assets of its owner
+func (t *AssetManagementChaincode) callerOperator(stub compat.Stub, asset string, record *assetRecord) ([]byte, error) {
+	assetOperators, err := getOperators(stub, compositeKey(assetOperatorsTable, asset, ""))
+	if err != nil {
+		return nil, err
+	}
+	ownerOperators, err := getOperators(stub, compositeKey(ownerOperatorsTable, fingerprint(record.Owner), ""))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, operator := range append(assetOperators, ownerOperators...) {
+		ok, err := t.isCaller(stub, operator)
+		if err != nil {
+			myLogger.Debugf("Failed checking operator identity [%s]: [%s]", fingerprint(operator), err)
+			continue
+		}
+		if ok {
+			return operator, nil
+		}
+	}
+	return nil, errors.New("The caller is neither the owner nor an operator of the asset")
+}
+
+// checkOwnerCert verifies that the caller is the holder of owner
+func (t *AssetManagementChaincode) checkOwnerCert(stub compat.Stub, owner []byte) error {
+	ok, err := t.isCaller(stub, owner)
+	if err != nil {
+		return errors.New("Failed checking owner identity")
+	}
+	if !ok {
+		return errors.New("The caller is not the holder of the owner certificate")
+	}
+	return nil
+}
+
+// approveOperator lets operator transfer asset until the asset changes owner. Only the owner can call this function.
+func (t *AssetManagementChaincode) approveOperator(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Approve operator...")
+
+	asset := args.String("asset")
+	operator := args.Bytes("operator")
+	if len(operator) == 0 {
+		return nil, errors.New("Invalid operator certificate. Empty.")
+	}
+
+	_, err := t.ownedAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.PutState(assetOperatorKey(asset, operator), operator)
+	if err != nil {
+		return nil, fmt.Errorf("Failed storing operator of [%s]: [%s]", asset, err)
+	}
+
+	myLogger.Debugf("Approve operator...done, [%s] is an operator of [%s]", fingerprint(operator), asset)
+
+	return nil, nil
+}
+
+// revokeOperator revokes the approval of operator for asset. Only the owner can call this function.
+func (t *AssetManagementChaincode) revokeOperator(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Revoke operator...")
+
+	asset := args.String("asset")
+	operator := args.Bytes("operator")
+
+	_, err := t.ownedAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := stub.GetState(assetOperatorKey(asset, operator))
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving operator of [%s]: [%s]", asset, err)
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("The certificate is not an operator of [%s]", asset)
+	}
+
+	err = stub.DelState(assetOperatorKey(asset, operator))
+	if err != nil {
+		return nil, fmt.Errorf("Failed revoking operator of [%s]: [%s]", asset, err)
+	}
+
+	myLogger.Debug("Revoke operator...done")
+
+	return nil, nil
+}
+
+// approveAll lets operator transfer every asset of owner, present and future.
+// Only the holder of owner can call this function.
+func (t *AssetManagementChaincode) approveAll(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Approve all...")
+
+	owner := args.Bytes("owner")
+	operator := args.Bytes("operator")
+	if len(operator) == 0 {
+		return nil, errors.New("Invalid operator certificate. Empty.")
+	}
+
+	err := t.checkOwnerCert(stub, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.PutState(ownerOperatorKey(owner, operator), operator)
+	if err != nil {
+		return nil, fmt.Errorf("Failed storing operator: [%s]", err)
+	}
+
+	myLogger.Debugf("Approve all...done, [%s] is an operator of [%s]", fingerprint(operator), fingerprint(owner))
+
+	return nil, nil
+}
+
+// revokeAll revokes the approval of operator for all the assets of owner. Only the holder of owner can call this function.
+func (t *AssetManagementChaincode) revokeAll(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Revoke all...")
+
+	owner := args.Bytes("owner")
+	operator := args.Bytes("operator")
+
+	err := t.checkOwnerCert(stub, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := stub.GetState(ownerOperatorKey(owner, operator))
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving operator: [%s]", err)
+	}
+	if existing == nil {
+		return nil, errors.New("The certificate is not an operator of the owner")
+	}
+
+	err = stub.DelState(ownerOperatorKey(owner, operator))
+	if err != nil {
+		return nil, fmt.Errorf("Failed revoking operator: [%s]", err)
+	}
+
+	myLogger.Debug("Revoke all...done")
+
+	return nil, nil
+}
+
+// operatorsOf returns the operators who can transfer asset as JSON. Anyone can call this function.
+func (t *AssetManagementChaincode) operatorsOf(stub compat.Stub, args *router.Args) ([]byte, error) {
+	asset := args.String("asset")
+
+	record, err := assignedAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []operatorEntry{}
+	assetOperators, err := getOperators(stub, compositeKey(assetOperatorsTable, asset, ""))
+	if err != nil {
+		return nil, err
+	}
+	for _, operator := range assetOperators {
+		entries = append(entries, operatorEntry{Fingerprint: fingerprint(operator), Certificate: operator, Scope: assetScope})
+	}
+
+	ownerOperators, err := getOperators(stub, compositeKey(ownerOperatorsTable, fingerprint(record.Owner), ""))
+	if err != nil {
+		return nil, err
+	}
+	for _, operator := range ownerOperators {
+		entries = append(entries, operatorEntry{Fingerprint: fingerprint(operator), Certificate: operator, Scope: allScope})
+	}
+
+	return json.Marshal(entries)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// ownerAssetsTable prefixes the composite keys ownerAssetsTable\x00fingerprint\x00asset indexing the assets of each owner
+const ownerAssetsTable = "OwnerAssets"
+
+// Paging limits of assets_of
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// assetsPage is the JSON document returned by assets_of.
+// Next is the continuation token for the following page, empty on the last page.
+type assetsPage struct {
+	Assets []string `json:"assets"`
+	Next   string   `json:"next,omitempty"`
+}
+
+// ownerAssetKey is the state key of the index entry of asset among the assets of owner
+func ownerAssetKey(owner []byte, asset string) string {
+	return compositeKey(ownerAssetsTable, fingerprint(owner), asset)
+}
+
+// assetsOf returns a page of the assets of owner. Anyone can call this function.
+func (t *AssetManagementChaincode) assetsOf(stub compat.Stub, args *router.Args) ([]byte, error) {
+	owner := args.Bytes("owner")
+
+	limit := defaultPageSize
+	if args.Has("limit") {
+		if args.Int("limit") < 1 || args.Int("limit") > maxPageSize {
+			return nil, fmt.Errorf("Invalid limit [%d]. Expecting a number between 1 and %d", args.Int("limit"), maxPageSize)
+		}
+		limit = int(args.Int("limit"))
+	}
+
+	prefix := ownerAssetKey(owner, "")
+	startKey, endKey := prefix, prefix+"\xff"
+	if args.Has("token") {
+		next, err := base64.URLEncoding.DecodeString(args.String("token"))
+		if err != nil {
+			return nil, errors.New("Invalid continuation token")
+		}
+		startKey = prefix + string(next)
+	}
+
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed querying assets: [%s]", err)
+	}
+	defer iter.Close()
+
+	page := assetsPage{Assets: []string{}}
+	for iter.HasNext() {
+		if len(page.Assets) == limit {
+			// Resume right after the last returned asset
+			last := page.Assets[limit-1]
+			page.Next = base64.URLEncoding.EncodeToString([]byte(last + compositeKeySeparator))
+			break
+		}
+
+		_, asset, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed iterating assets: [%s]", err)
+		}
+		page.Assets = append(page.Assets, string(asset))
+	}
+
+	return json.Marshal(page)
+}
@@ -0,0 +1,230 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// ownershipTable is the name of the table the ownership of assets used to be stored in.
+// The ownership of an asset is now stored under the composite key ownershipTable\x00asset.
+const ownershipTable = "AssetsOwnership"
+
+// compositeKeySeparator separates the parts of composite keys, so assets can't contain it
+const compositeKeySeparator = "\x00"
+
+// assetRecord is the state stored for an assigned asset
+type assetRecord struct {
+	Owner  []byte `json:"owner"`            // DER certificate of the owner
+	Offer  []byte `json:"offer,omitempty"`  // DER certificate of the recipient of the pending offer, if any
+	Frozen bool   `json:"frozen,omitempty"` // whether the owner can't change
+	Reason string `json:"reason,omitempty"` // why the asset is frozen
+}
+
+// assetStatus is the JSON document returned by query
+type assetStatus struct {
+	Owner  []byte `json:"owner"` // DER certificate of the owner, base64 encoded by encoding/json
+	Frozen bool   `json:"frozen"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ownershipRow is a row of the AssetsOwnership table
+type ownershipRow struct {
+	Asset string
+	Owner []byte
+}
+
+// compositeKey joins the parts of a composite key
+func compositeKey(parts ...string) string {
+	return strings.Join(parts, compositeKeySeparator)
+}
+
+// ownershipKey is the state key of the record of asset
+func ownershipKey(asset string) string {
+	return compositeKey(ownershipTable, asset)
+}
+
+// validateAsset rejects asset names that can't be stored in a composite key
+func validateAsset(asset string) error {
+	if asset == "" {
+		return errors.New("Invalid asset. Empty.")
+	}
+	if strings.Contains(asset, compositeKeySeparator) {
+		return fmt.Errorf("Invalid asset [%q]. Assets can't contain NUL characters", asset)
+	}
+	if !utf8.ValidString(asset) {
+		// assets_of pages up to the separator followed by 0xff, which only sorts after valid UTF-8
+		return fmt.Errorf("Invalid asset [%q]. Assets must be valid UTF-8", asset)
+	}
+	return nil
+}
+
+// getAsset returns the record of asset, or nil if it was never assigned
+func getAsset(stub compat.Stub, asset string) (*assetRecord, error) {
+	recordAsBytes, err := stub.GetState(ownershipKey(asset))
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving asset [%s]: [%s]", asset, err)
+	}
+	if len(recordAsBytes) == 0 {
+		return nil, nil
+	}
+
+	record := &assetRecord{}
+	err = json.Unmarshal(recordAsBytes, record)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decoding asset [%s]: [%s]", asset, err)
+	}
+	return record, nil
+}
+
+// assignedAsset returns the record of asset, which must be assigned
+func assignedAsset(stub compat.Stub, asset string) (*assetRecord, error) {
+	record, err := getAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || len(record.Owner) == 0 {
+		return nil, fmt.Errorf("Asset [%s] not assigned", asset)
+	}
+	return record, nil
+}
+
+// putAsset stores the record of asset
+func putAsset(stub compat.Stub, asset string, record *assetRecord) error {
+	recordAsBytes, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("Failed encoding asset [%s]: [%s]", asset, err)
+	}
+
+	err = stub.PutState(ownershipKey(asset), recordAsBytes)
+	if err != nil {
+		return fmt.Errorf("Failed storing asset [%s]: [%s]", asset, err)
+	}
+	return nil
+}
+
+// releaseOwner removes asset from the assets of its current owner, withdraws the pending offer
+// and revokes the operators approved for asset alone. The caller stores or deletes the record.
+func releaseOwner(stub compat.Stub, asset string, record *assetRecord) error {
+	if len(record.Owner) != 0 {
+		err := stub.DelState(ownerAssetKey(record.Owner, asset))
+		if err != nil {
+			return fmt.Errorf("Failed removing [%s] from the assets of its owner: [%s]", asset, err)
+		}
+	}
+
+	err := clearOffer(stub, asset, record)
+	if err != nil {
+		return err
+	}
+
+	return clearOperators(stub, asset)
+}
+
+// setOwner makes owner the owner of asset, whose current record is nil if it was never assigned,
+// releasing it from its current owner and keeping the owner index in sync
+func setOwner(stub compat.Stub, asset string, record *assetRecord, owner []byte) error {
+	if record == nil {
+		record = &assetRecord{}
+	} else {
+		err := releaseOwner(stub, asset, record)
+		if err != nil {
+			return err
+		}
+	}
+
+	record.Owner = owner
+	err := putAsset(stub, asset, record)
+	if err != nil {
+		return err
+	}
+
+	err = stub.PutState(ownerAssetKey(owner, asset), []byte(asset))
+	if err != nil {
+		return fmt.Errorf("Failed adding [%s] to the assets of its owner: [%s]", asset, err)
+	}
+	return nil
+}
+
+// migrateTables copies the rows of the AssetsOwnership table of a deployment predating
+// composite keys into the key/value state. Assets already stored in the new layout, or
+// revoked since, are left untouched, so the migration can be run again safely.
+// Only an administrator can call this function.
+func (t *AssetManagementChaincode) migrateTables(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Migrate tables...")
+
+	adminCert, err := t.callerAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := ownershipRows(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := 0
+	for _, row := range rows {
+		asset := row.Asset
+
+		err = validateAsset(asset)
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := getAsset(stub, asset)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			myLogger.Debugf("Asset [%s] already migrated", asset)
+			continue
+		}
+
+		revocation, err := getRevocation(stub, asset)
+		if err != nil {
+			return nil, err
+		}
+		if revocation != nil {
+			myLogger.Debugf("Asset [%s] was revoked", asset)
+			continue
+		}
+
+		err = setOwner(stub, asset, nil, row.Owner)
+		if err != nil {
+			return nil, err
+		}
+
+		err = appendCustody(stub, asset, migrateOp, adminCert, row.Owner, nil)
+		if err != nil {
+			return nil, err
+		}
+		migrated++
+	}
+
+	myLogger.Debugf("Migrate tables...done, %d assets migrated", migrated)
+
+	return []byte(strconv.Itoa(migrated)), nil
+}
@@ -0,0 +1,174 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// provenanceTable prefixes the composite keys of the chain of custody of each asset.
+// provenanceTable\x00asset holds the length of the chain, and
+// provenanceTable\x00asset\x00seq the entry with the given sequence number.
+const provenanceTable = "Provenance"
+
+// Operations recorded in the chain of custody
+const (
+	assignOp   = "assign"
+	transferOp = "transfer"
+	migrateOp  = "migrate"
+	revokeOp   = "revoke"
+	reassignOp = "reassign"
+)
+
+// custodyEntry is one change of owner of an asset. Entries are never changed or removed.
+type custodyEntry struct {
+	Seq       uint64 `json:"seq"`
+	Op        string `json:"op"`
+	From      string `json:"from"`             // hex SHA-256 fingerprint of the assigning administrator or of the previous owner
+	To        string `json:"to"`               // hex SHA-256 fingerprint of the new owner, or of the revoking administrator
+	By        string `json:"by,omitempty"`     // hex SHA-256 fingerprint of the operator transferring the asset, if any
+	Reason    string `json:"reason,omitempty"` // why the asset was revoked
+	TxID      string `json:"txID"`
+	Timestamp string `json:"timestamp"`
+}
+
+// custodyLengthKey is the state key of the length of the chain of custody of asset
+func custodyLengthKey(asset string) string {
+	return compositeKey(provenanceTable, asset)
+}
+
+// custodyKey is the state key of the entry of the chain of custody of asset with the given sequence number
+func custodyKey(asset string, seq uint64) string {
+	return compositeKey(provenanceTable, asset, fmt.Sprintf("%020d", seq))
+}
+
+// txTime returns the timestamp of the current transaction
+func txTime(stub compat.Stub) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed getting transaction timestamp: [%s]", err)
+	}
+	if ts == nil {
+		return time.Time{}, errors.New("Failed getting transaction timestamp. Nil")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// custodyLength returns the number of entries in the chain of custody of asset
+func custodyLength(stub compat.Stub, asset string) (uint64, error) {
+	lengthAsBytes, err := stub.GetState(custodyLengthKey(asset))
+	if err != nil {
+		return 0, fmt.Errorf("Failed retrieving provenance of [%s]: [%s]", asset, err)
+	}
+	if len(lengthAsBytes) == 0 {
+		return 0, nil
+	}
+
+	length, err := strconv.ParseUint(string(lengthAsBytes), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Failed decoding provenance of [%s]: [%s]", asset, err)
+	}
+	return length, nil
+}
+
+// appendCustody appends to the chain of custody of asset the change of owner
+// from the holder of the from certificate to the holder of the to certificate,
+// made by the holder of the by certificate when it is neither of them
+func appendCustody(stub compat.Stub, asset, op string, from, to, by []byte) error {
+	entry := custodyEntry{
+		Op:   op,
+		From: fingerprint(from),
+		To:   fingerprint(to),
+	}
+	if len(by) != 0 {
+		entry.By = fingerprint(by)
+	}
+	return appendEntry(stub, asset, entry)
+}
+
+// appendEntry appends entry to the chain of custody of asset, stamped with its position and the current transaction
+func appendEntry(stub compat.Stub, asset string, entry custodyEntry) error {
+	seq, err := custodyLength(stub, asset)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+
+	entry.Seq = seq
+	entry.TxID = stub.GetTxID()
+	entry.Timestamp = now.Format(time.RFC3339Nano)
+
+	entryAsBytes, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Failed encoding provenance of [%s]: [%s]", asset, err)
+	}
+
+	err = stub.PutState(custodyKey(asset, seq), entryAsBytes)
+	if err != nil {
+		return fmt.Errorf("Failed storing provenance of [%s]: [%s]", asset, err)
+	}
+
+	err = stub.PutState(custodyLengthKey(asset), []byte(strconv.FormatUint(seq+1, 10)))
+	if err != nil {
+		return fmt.Errorf("Failed storing provenance of [%s]: [%s]", asset, err)
+	}
+	return nil
+}
+
+// provenance returns the chain of custody of asset as JSON, oldest first. Anyone can call this function.
+func (t *AssetManagementChaincode) provenance(stub compat.Stub, args *router.Args) ([]byte, error) {
+	asset := args.String("asset")
+	err := validateAsset(asset)
+	if err != nil {
+		return nil, err
+	}
+
+	startKey := compositeKey(provenanceTable, asset, "")
+	iter, err := stub.RangeQueryState(startKey, startKey+"\xff")
+	if err != nil {
+		return nil, fmt.Errorf("Failed querying provenance of [%s]: [%s]", asset, err)
+	}
+	defer iter.Close()
+
+	entries := []custodyEntry{}
+	for iter.HasNext() {
+		_, entryAsBytes, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed iterating provenance of [%s]: [%s]", asset, err)
+		}
+
+		var entry custodyEntry
+		err = json.Unmarshal(entryAsBytes, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("Failed decoding provenance of [%s]: [%s]", asset, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return json.Marshal(entries)
+}
@@ -0,0 +1,228 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// revocationsTable prefixes the composite keys revocationsTable\x00asset holding the last revocation
+// of assets, which is kept and marked when the asset is reassigned
+const revocationsTable = "Revocations"
+
+// revocationRecord is the state stored for a revoked asset
+type revocationRecord struct {
+	Admin     string `json:"admin"` // hex SHA-256 fingerprint of the revoking administrator
+	Owner     string `json:"owner"` // hex SHA-256 fingerprint of the last owner
+	Reason    string `json:"reason"`
+	TxID      string `json:"txID"`
+	Timestamp string `json:"timestamp"`
+
+	ReassignedBy        string `json:"reassignedBy,omitempty"` // hex SHA-256 fingerprint of the reassigning administrator
+	ReassignedTxID      string `json:"reassignedTxID,omitempty"`
+	ReassignedTimestamp string `json:"reassignedTimestamp,omitempty"`
+}
+
+// reassigned tells whether the revoked asset was reassigned since
+func (r *revocationRecord) reassigned() bool {
+	return r.ReassignedTxID != ""
+}
+
+// revocationKey is the state key of the revocation of asset
+func revocationKey(asset string) string {
+	return compositeKey(revocationsTable, asset)
+}
+
+// getRevocation returns the last revocation of asset, or nil if it was never revoked
+func getRevocation(stub compat.Stub, asset string) (*revocationRecord, error) {
+	revocationAsBytes, err := stub.GetState(revocationKey(asset))
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving revocation of [%s]: [%s]", asset, err)
+	}
+	if len(revocationAsBytes) == 0 {
+		return nil, nil
+	}
+
+	revocation := &revocationRecord{}
+	err = json.Unmarshal(revocationAsBytes, revocation)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decoding revocation of [%s]: [%s]", asset, err)
+	}
+	return revocation, nil
+}
+
+// checkNotRevoked refuses to assign a revoked asset
+func checkNotRevoked(stub compat.Stub, asset string) error {
+	revocation, err := getRevocation(stub, asset)
+	if err != nil {
+		return err
+	}
+	if revocation != nil && !revocation.reassigned() {
+		return fmt.Errorf("Asset [%s] was revoked by transaction [%s]. Use reassign", asset, revocation.TxID)
+	}
+	return nil
+}
+
+// revoke deletes the ownership of asset, recording who revoked it, when and why.
+// Only an administrator can call this function.
+func (t *AssetManagementChaincode) revoke(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Revoke...")
+
+	asset := args.String("asset")
+	reason := args.String("reason")
+	if reason == "" {
+		return nil, errors.New("Invalid reason. Empty.")
+	}
+
+	adminCert, err := t.callerAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := assignedAsset(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	revocationAsBytes, err := json.Marshal(revocationRecord{
+		Admin:     fingerprint(adminCert),
+		Owner:     fingerprint(record.Owner),
+		Reason:    reason,
+		TxID:      stub.GetTxID(),
+		Timestamp: now.Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed encoding revocation of [%s]: [%s]", asset, err)
+	}
+
+	err = stub.PutState(revocationKey(asset), revocationAsBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed storing revocation of [%s]: [%s]", asset, err)
+	}
+
+	err = releaseOwner(stub, asset, record)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.DelState(ownershipKey(asset))
+	if err != nil {
+		return nil, fmt.Errorf("Failed deleting asset [%s]: [%s]", asset, err)
+	}
+
+	err = appendEntry(stub, asset, custodyEntry{
+		Op:     revokeOp,
+		From:   fingerprint(record.Owner),
+		To:     fingerprint(adminCert),
+		Reason: reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	myLogger.Debugf("Revoke...done, [%s] is revoked", asset)
+
+	return nil, nil
+}
+
+// reassign assigns a revoked asset to owner. The caller names the transaction that revoked the asset,
+// so that a revocation can't be overridden by mistake. The revocation record is kept, marked with
+// who reassigned the asset and when. Only an administrator can call this function.
+func (t *AssetManagementChaincode) reassign(stub compat.Stub, args *router.Args) ([]byte, error) {
+	myLogger.Debug("Reassign...")
+
+	asset := args.String("asset")
+	owner := args.Bytes("owner")
+	revocationTxID := args.String("revocationTxID")
+	if len(owner) == 0 {
+		return nil, errors.New("Invalid owner certificate. Empty.")
+	}
+
+	adminCert, err := t.callerAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	revocation, err := getRevocation(stub, asset)
+	if err != nil {
+		return nil, err
+	}
+	if revocation == nil {
+		return nil, fmt.Errorf("Asset [%s] is not revoked", asset)
+	}
+	if revocation.reassigned() {
+		return nil, fmt.Errorf("Asset [%s] was already reassigned by transaction [%s]", asset, revocation.ReassignedTxID)
+	}
+	if revocation.TxID != revocationTxID {
+		return nil, fmt.Errorf("Asset [%s] was revoked by transaction [%s], not [%s]", asset, revocation.TxID, revocationTxID)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	revocation.ReassignedBy = fingerprint(adminCert)
+	revocation.ReassignedTxID = stub.GetTxID()
+	revocation.ReassignedTimestamp = now.Format(time.RFC3339Nano)
+	revocationAsBytes, err := json.Marshal(revocation)
+	if err != nil {
+		return nil, fmt.Errorf("Failed encoding revocation of [%s]: [%s]", asset, err)
+	}
+
+	err = stub.PutState(revocationKey(asset), revocationAsBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed storing revocation of [%s]: [%s]", asset, err)
+	}
+
+	err = setOwner(stub, asset, nil, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = appendCustody(stub, asset, reassignOp, adminCert, owner, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	myLogger.Debugf("Reassign...done, new owner of [%s] is [% x]", asset, owner)
+
+	return nil, nil
+}
+
+// revocation returns the last revocation of asset as JSON, or nothing if it was never revoked. Anyone can call this function.
+func (t *AssetManagementChaincode) revocation(stub compat.Stub, args *router.Args) ([]byte, error) {
+	revocation, err := getRevocation(stub, args.String("asset"))
+	if err != nil {
+		return nil, err
+	}
+	if revocation == nil {
+		return nil, nil
+	}
+	return json.Marshal(revocation)
+}
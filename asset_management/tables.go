@@ -0,0 +1,50 @@
+//go:build !fabric1
+// +build !fabric1
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/shiliy/learn-chaincode/compat"
+)
+
+// setSecurityLevel configures the crypto primitives of the Fabric 0.6 shim
+func setSecurityLevel() {
+	primitives.SetSecurityLevel("SHA3", 256)
+}
+
+// ownershipRows reads all the rows of the AssetsOwnership table
+func ownershipRows(stub compat.Stub) ([]ownershipRow, error) {
+	rows, err := stub.GetRows(ownershipTable, []shim.Column{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading table [%s]: [%s]", ownershipTable, err)
+	}
+
+	result := []ownershipRow{}
+	for row := range rows {
+		if len(row.Columns) != 2 {
+			return nil, fmt.Errorf("Invalid row in table [%s]: %d columns", ownershipTable, len(row.Columns))
+		}
+		result = append(result, ownershipRow{Asset: row.Columns[0].GetString_(), Owner: row.Columns[1].GetBytes()})
+	}
+	return result, nil
+}
@@ -0,0 +1,35 @@
+//go:build fabric1
+// +build fabric1
+
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+
+	"github.com/shiliy/learn-chaincode/compat"
+)
+
+// setSecurityLevel does nothing, Fabric 1.x has no configurable crypto primitives
+func setSecurityLevel() {
+}
+
+// ownershipRows fails, Fabric 1.x has no table API. Tables must be migrated before upgrading.
+func ownershipRows(stub compat.Stub) ([]ownershipRow, error) {
+	return nil, errors.New("The table API is not available on Fabric 1.x. Migrate the tables before upgrading")
+}
@@ -0,0 +1,55 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package compat lets the chaincodes of this repository, written against the
+// Init/Invoke/Query(stub, function, args) ([]byte, error) interface of Fabric 0.6,
+// run unchanged on Fabric 1.x, whose chaincodes implement Init(stub) pb.Response and
+// Invoke(stub) pb.Response and read their function with GetFunctionAndParameters.
+//
+// Chaincodes take a compat.Stub instead of a shim.ChaincodeStubInterface and start
+// with compat.Start:
+//
+//	func main() {
+//		err := compat.Start(new(SimpleChaincode))
+//		...
+//	}
+//
+// By default the package builds against the Fabric 0.6 shim, where Stub is the shim
+// stub itself. Building with the fabric1 tag targets the Fabric 1.x shim instead: Stub
+// then wraps the 1.x stub, and an Adapter dispatches every Invoke to the Invoke or the
+// Query method of the chaincode, depending on whether the chaincode reports the function
+// as a query. Fabric 1.x reserves the keys starting with NUL for composite keys, the 1.x
+// Stub serves range queries over them through the partial composite key query.
+package compat
+
+// LegacyChaincode is the interface of Fabric 0.6 chaincodes
+type LegacyChaincode interface {
+	// Init is called once, when the chaincode is deployed
+	Init(stub Stub, function string, args []string) ([]byte, error)
+
+	// Invoke is called for transactions that may change the state
+	Invoke(stub Stub, function string, args []string) ([]byte, error)
+
+	// Query is called for read-only requests
+	Query(stub Stub, function string, args []string) ([]byte, error)
+}
+
+// QueryRouter is implemented by chaincodes that tell their queries apart from their invocations.
+// On Fabric 1.x, where everything reaches the chaincode through Invoke, the functions it reports
+// as queries are passed to the Query method of the chaincode.
+type QueryRouter interface {
+	IsQuery(function string) bool
+}
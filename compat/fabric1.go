@@ -0,0 +1,264 @@
+//go:build fabric1
+// +build fabric1
+
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compat
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// MetadataKey is the transient field holding what Fabric 0.6 called the transaction metadata,
+// such as the signature checked by the asset management example
+const MetadataKey = "metadata"
+
+// compositeKeyNamespace starts the keys Fabric 1.x reserves for composite keys.
+// Its range query rejects them, they can only be ranged over by partial composite key.
+const compositeKeyNamespace = "\x00"
+
+// Iterator iterates over the keys of a range query
+type Iterator interface {
+	HasNext() bool
+	Next() (string, []byte, error)
+	Close() error
+}
+
+// Stub is the part of the Fabric 0.6 shim stub used by the chaincodes, implemented over the Fabric 1.x shim
+type Stub interface {
+	GetTxID() string
+	GetTxTimestamp() (*timestamp.Timestamp, error)
+
+	GetState(key string) ([]byte, error)
+	PutState(key string, value []byte) error
+	DelState(key string) error
+	RangeQueryState(startKey, endKey string) (Iterator, error)
+
+	// GetCallerCertificate returns the serialized identity of the transaction creator
+	GetCallerCertificate() ([]byte, error)
+	// GetCallerMetadata returns the MetadataKey field of the transient data
+	GetCallerMetadata() ([]byte, error)
+	// GetPayload returns the arguments of the transaction, concatenated
+	GetPayload() ([]byte, error)
+	GetBinding() ([]byte, error)
+	// VerifySignature verifies the ECDSA signature over SHA-256(message) under the PEM or DER certificate
+	VerifySignature(certificate, signature, message []byte) (bool, error)
+
+	SetEvent(name string, payload []byte) error
+
+	InvokeChaincode(chaincodeName string, args [][]byte) ([]byte, error)
+	QueryChaincode(chaincodeName string, args [][]byte) ([]byte, error)
+}
+
+// stub implements Stub over a Fabric 1.x stub
+type stub struct {
+	shim.ChaincodeStubInterface
+}
+
+// iterator adapts a Fabric 1.x range query iterator
+type iterator struct {
+	shim.StateQueryIteratorInterface
+}
+
+func (i iterator) Next() (string, []byte, error) {
+	kv, err := i.StateQueryIteratorInterface.Next()
+	if err != nil {
+		return "", nil, err
+	}
+	return kv.Key, kv.Value, nil
+}
+
+// boundedIterator keeps the keys of a sorted iterator between startKey (inclusive) and endKey (exclusive)
+type boundedIterator struct {
+	Iterator
+	startKey, endKey string
+
+	key   string
+	value []byte
+	err   error
+	ready bool // key, value and err hold the next result
+	done  bool // endKey was reached
+}
+
+func (i *boundedIterator) HasNext() bool {
+	for !i.ready && !i.done && i.Iterator.HasNext() {
+		i.key, i.value, i.err = i.Iterator.Next()
+		switch {
+		case i.err != nil:
+			i.ready = true
+		case i.key >= i.endKey:
+			i.done = true
+		default:
+			i.ready = i.key >= i.startKey
+		}
+	}
+	return i.ready
+}
+
+func (i *boundedIterator) Next() (string, []byte, error) {
+	if !i.HasNext() {
+		return "", nil, errors.New("No more keys in range")
+	}
+	i.ready = false
+	return i.key, i.value, i.err
+}
+
+// partialCompositeKey splits the longest composite key prefix shared by startKey and endKey,
+// made of whole parts, into the object type and attributes of a partial composite key
+func partialCompositeKey(startKey, endKey string) (string, []string, error) {
+	common := 0
+	for common < len(startKey) && common < len(endKey) && startKey[common] == endKey[common] {
+		common++
+	}
+
+	// Drop the last part, which is empty or cut short
+	parts := strings.Split(startKey[:common], compositeKeyNamespace)
+	if len(parts) < 3 || parts[0] != "" {
+		return "", nil, fmt.Errorf("Unsupported range [%q, %q). Keys starting with NUL must share their first part", startKey, endKey)
+	}
+	return parts[1], parts[2 : len(parts)-1], nil
+}
+
+// RangeQueryState returns the keys between startKey (inclusive) and endKey (exclusive).
+// Ranges over keys starting with NUL go through the partial composite key query.
+func (s stub) RangeQueryState(startKey, endKey string) (Iterator, error) {
+	if !strings.HasPrefix(startKey, compositeKeyNamespace) {
+		iter, err := s.GetStateByRange(startKey, endKey)
+		if err != nil {
+			return nil, err
+		}
+		return iterator{iter}, nil
+	}
+
+	objectType, attributes, err := partialCompositeKey(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := s.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return &boundedIterator{Iterator: iterator{iter}, startKey: startKey, endKey: endKey}, nil
+}
+
+func (s stub) GetCallerCertificate() ([]byte, error) {
+	return s.GetCreator()
+}
+
+func (s stub) GetCallerMetadata() ([]byte, error) {
+	transient, err := s.GetTransient()
+	if err != nil {
+		return nil, err
+	}
+	return transient[MetadataKey], nil
+}
+
+func (s stub) GetPayload() ([]byte, error) {
+	return s.GetArgsSlice()
+}
+
+func (s stub) VerifySignature(certificate, signature, message []byte) (bool, error) {
+	if block, _ := pem.Decode(certificate); block != nil {
+		certificate = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(certificate)
+	if err != nil {
+		return false, fmt.Errorf("Failed parsing certificate: [%s]", err)
+	}
+	key, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return false, errors.New("Unsupported certificate. Expecting an ECDSA key")
+	}
+
+	var sig struct {
+		R, S *big.Int
+	}
+	_, err = asn1.Unmarshal(signature, &sig)
+	if err != nil {
+		return false, nil
+	}
+
+	digest := sha256.Sum256(message)
+	return ecdsa.Verify(key, digest[:], sig.R, sig.S), nil
+}
+
+func (s stub) InvokeChaincode(chaincodeName string, args [][]byte) ([]byte, error) {
+	return payload(s.ChaincodeStubInterface.InvokeChaincode(chaincodeName, args, ""))
+}
+
+func (s stub) QueryChaincode(chaincodeName string, args [][]byte) ([]byte, error) {
+	return payload(s.ChaincodeStubInterface.InvokeChaincode(chaincodeName, args, ""))
+}
+
+// payload returns the payload of a successful response, or its message as an error
+func payload(response pb.Response) ([]byte, error) {
+	if response.Status != shim.OK {
+		return nil, errors.New(response.Message)
+	}
+	return response.Payload, nil
+}
+
+// Adapter runs a LegacyChaincode on the Fabric 1.x shim
+type Adapter struct {
+	cc LegacyChaincode
+}
+
+// NewAdapter returns cc as a Fabric 1.x chaincode
+func NewAdapter(cc LegacyChaincode) *Adapter {
+	return &Adapter{cc: cc}
+}
+
+// Start runs cc on the Fabric 1.x shim
+func Start(cc LegacyChaincode) error {
+	return shim.Start(NewAdapter(cc))
+}
+
+// Init calls the Init method of the chaincode with the function and arguments of the transaction
+func (a *Adapter) Init(s shim.ChaincodeStubInterface) pb.Response {
+	function, args := s.GetFunctionAndParameters()
+	return response(a.cc.Init(stub{s}, function, args))
+}
+
+// Invoke calls the Query method of the chaincode for the functions it reports as queries,
+// and its Invoke method otherwise
+func (a *Adapter) Invoke(s shim.ChaincodeStubInterface) pb.Response {
+	function, args := s.GetFunctionAndParameters()
+	if router, ok := a.cc.(QueryRouter); ok && router.IsQuery(function) {
+		return response(a.cc.Query(stub{s}, function, args))
+	}
+	return response(a.cc.Invoke(stub{s}, function, args))
+}
+
+// response converts the result of a legacy function to a Fabric 1.x response
+func response(result []byte, err error) pb.Response {
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(result)
+}
@@ -0,0 +1,56 @@
+//go:build !fabric1
+// +build !fabric1
+
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compat
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Stub is the Fabric 0.6 shim stub
+type Stub interface {
+	shim.ChaincodeStubInterface
+}
+
+// chaincode runs a LegacyChaincode on the Fabric 0.6 shim
+type chaincode struct {
+	cc LegacyChaincode
+}
+
+// Wrap returns cc as a Fabric 0.6 shim chaincode, for example to run it in a shim.MockStub
+func Wrap(cc LegacyChaincode) shim.Chaincode {
+	return &chaincode{cc: cc}
+}
+
+// Start runs cc on the Fabric 0.6 shim
+func Start(cc LegacyChaincode) error {
+	return shim.Start(Wrap(cc))
+}
+
+func (c *chaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	return c.cc.Init(stub, function, args)
+}
+
+func (c *chaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	return c.cc.Invoke(stub, function, args)
+}
+
+func (c *chaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	return c.cc.Query(stub, function, args)
+}
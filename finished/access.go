@@ -0,0 +1,117 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shiliy/learn-chaincode/compat"
+)
+
+// adminKey holds the certificate of the administrator set at deploy time
+var adminKey = internalKey(configKind, "admin")
+
+// isCaller verifies that the transaction was signed with the key of certificate.
+// As in the asset management example, the metadata must contain the signature under
+// the signing key of certificate of the transaction payload and binding.
+func isCaller(stub compat.Stub, certificate []byte) (bool, error) {
+	// Verify \sigma=Sign(certificate.sk, tx.Payload||tx.Binding) against certificate.vk
+	// \sigma is in the metadata
+	sigma, err := stub.GetCallerMetadata()
+	if err != nil {
+		return false, errors.New("Failed getting metadata")
+	}
+	payload, err := stub.GetPayload()
+	if err != nil {
+		return false, errors.New("Failed getting payload")
+	}
+	binding, err := stub.GetBinding()
+	if err != nil {
+		return false, errors.New("Failed getting binding")
+	}
+
+	ok, err := stub.VerifySignature(certificate, sigma, append(payload, binding...))
+	if err != nil {
+		fmt.Printf("Failed checking signature [%s]\n", err)
+		return false, err
+	}
+	return ok, nil
+}
+
+// isAdmin verifies that the transaction was signed by the administrator, if one was set at deploy time
+func isAdmin(stub compat.Stub) (bool, error) {
+	adminCert, err := stub.GetState(adminKey)
+	if err != nil {
+		return false, errors.New("Failed fetching admin identity")
+	}
+	if len(adminCert) == 0 {
+		return false, nil
+	}
+
+	ok, err := isCaller(stub, adminCert)
+	if err != nil {
+		return false, errors.New("Failed checking admin identity")
+	}
+	return ok, nil
+}
+
+// checkOwner verifies that the caller may overwrite or delete key: either the key has no owner,
+// or the transaction was signed by its owner or by the administrator
+func checkOwner(stub compat.Stub, key string, meta *keyMeta) error {
+	if len(meta.Owner) == 0 {
+		return nil
+	}
+
+	ok, err := isCaller(stub, meta.Owner)
+	if err != nil {
+		return errors.New("Failed checking owner identity")
+	}
+	if ok {
+		return nil
+	}
+
+	ok, err = isAdmin(stub)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("The caller is not the owner of [%s]", key)
+	}
+	return nil
+}
+
+// checkAdmin verifies that the transaction was signed by the administrator.
+// Without an administrator configured at deploy time, nobody passes.
+func checkAdmin(stub compat.Stub) error {
+	adminCert, err := stub.GetState(adminKey)
+	if err != nil {
+		return errors.New("Failed fetching admin identity")
+	}
+	if len(adminCert) == 0 {
+		return errors.New("Denied, no administrator configured")
+	}
+
+	ok, err := isAdmin(stub)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("The caller is not an administrator")
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// maxBatchSize bounds the number of operations of a single batch
+const maxBatchSize = 1000
+
+// writeOp is one key/value pair of a write_batch document
+type writeOp struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value"`
+}
+
+// writeBatch - invoke function to write several key/value pairs all-or-nothing.
+// The batch is a JSON array of {"key": ..., "value": ...} objects.
+func (t *SimpleChaincode) writeBatch(stub compat.Stub, args *router.Args) ([]byte, error) {
+	fmt.Println("running writeBatch()")
+
+	var ops []writeOp
+	err := json.Unmarshal([]byte(args.String("batch")), &ops)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid batch document: [%s]", err)
+	}
+	if len(ops) == 0 || len(ops) > maxBatchSize {
+		return nil, fmt.Errorf("Invalid batch size %d. Expecting between 1 and %d operations", len(ops), maxBatchSize)
+	}
+
+	// Validate every operation before touching the state
+	seen := make(map[string]bool, len(ops))
+	for i, op := range ops {
+		if op.Value == nil {
+			return nil, fmt.Errorf("Invalid operation %d: missing value for [%s]", i, op.Key)
+		}
+		if seen[op.Key] {
+			return nil, fmt.Errorf("Invalid operation %d: duplicate key [%s]", i, op.Key)
+		}
+		seen[op.Key] = true
+
+		err = checkWrite(stub, op.Key, []byte(*op.Value))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid operation %d: %s", i, err)
+		}
+	}
+
+	changes := make([]keyChange, 0, len(ops))
+	for _, op := range ops {
+		change, err := setValue(stub, op.Key, []byte(*op.Value), 0)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	err = emitChanges(stub, changes...)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// deleteBatch - invoke function to delete several keys, given as a JSON array, all-or-nothing
+func (t *SimpleChaincode) deleteBatch(stub compat.Stub, args *router.Args) ([]byte, error) {
+	fmt.Println("running deleteBatch()")
+
+	var keys []string
+	err := json.Unmarshal([]byte(args.String("keys")), &keys)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid batch document: [%s]", err)
+	}
+	if len(keys) == 0 || len(keys) > maxBatchSize {
+		return nil, fmt.Errorf("Invalid batch size %d. Expecting between 1 and %d keys", len(keys), maxBatchSize)
+	}
+
+	// Validate every key before touching the state
+	seen := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		if seen[key] {
+			return nil, fmt.Errorf("Invalid operation %d: duplicate key [%s]", i, key)
+		}
+		seen[key] = true
+
+		err = checkDelete(stub, key)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid operation %d: %s", i, err)
+		}
+	}
+
+	changes := make([]keyChange, 0, len(keys))
+	for _, key := range keys {
+		change, err := removeValue(stub, key, false)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	err = emitChanges(stub, changes...)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
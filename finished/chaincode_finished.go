@@ -17,30 +17,109 @@ limitations under the License.
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 
-	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
 )
 
+// Paging limits for the list and range queries
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// lastKey sorts after every valid UTF-8 key and bounds open-ended range queries.
+// Range queries return keys in sorted order, which the paging of list, range,
+// find and export and the leaves of the state digest rely on.
+const lastKey = "\xff"
+
+// keyValue is a single entry of a list or range query result
+type keyValue struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value,omitempty"`
+}
+
+// versionedValue is the JSON document returned by the read_versioned query
+type versionedValue struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Version uint64 `json:"version"`
+}
+
+// versionConflict is the JSON error returned by write_if when the stored version differs
+type versionConflict struct {
+	Error    string `json:"Error"`
+	Key      string `json:"key"`
+	Expected uint64 `json:"expected"`
+	Actual   uint64 `json:"actual"`
+}
+
+// keyPage is the JSON document returned by the list and range queries.
+// Next is the continuation token for the following page, empty on the last page.
+type keyPage struct {
+	Results []keyValue `json:"results"`
+	Next    string     `json:"next,omitempty"`
+}
+
+// pageArgs are the optional paging arguments shared by the listing queries
+var pageArgs = []router.Arg{
+	{Name: "limit", Type: router.Int, Optional: true},
+	{Name: "token", Optional: true},
+	{Name: "values", Type: router.Bool, Optional: true},
+}
+
 // SimpleChaincode example simple Chaincode implementation
 type SimpleChaincode struct {
+	once   sync.Once
+	routes *router.Router
 }
 
 func main() {
-	err := shim.Start(new(SimpleChaincode))
+	err := compat.Start(new(SimpleChaincode))
 	if err != nil {
 		fmt.Printf("Error starting Simple chaincode: %s", err)
 	}
 }
 
-// Init resets all the things
-func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+// Init resets all the things.
+// The deploy transaction metadata may contain the certificate of an administrator
+// allowed to overwrite and delete any key. Without one, the administrator functions
+// are denied. An optional second argument lists, as a JSON array, the names of the
+// chaincodes read_remote and write_from_remote may query.
+func (t *SimpleChaincode) Init(stub compat.Stub, function string, args []string) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1 or 2")
 	}
 
-	err := stub.PutState("hello_world", []byte(args[0]))
+	adminCert, err := stub.GetCallerMetadata()
+	if err != nil {
+		return nil, errors.New("Failed getting metadata.")
+	}
+	if len(adminCert) != 0 {
+		err = stub.PutState(adminKey, adminCert)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(args) == 2 {
+		err = setRemotes(stub, args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	change, err := setValue(stub, "hello_world", []byte(args[0]), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitChanges(stub, change)
 	if err != nil {
 		return nil, err
 	}
@@ -48,47 +127,276 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	return nil, nil
 }
 
+// functions returns the registry of the functions callable through Invoke and Query
+func (t *SimpleChaincode) functions() *router.Router {
+	t.once.Do(func() {
+		t.routes = router.New().
+			Invoke(router.Function{
+				Name:        "init",
+				Description: "Resets hello_world, the administrator is set once at deploy time",
+				Args:        []router.Arg{{Name: "value"}},
+				Handler:     t.reinit,
+			}).
+			Invoke(router.Function{
+				Name:        "write",
+				Description: "Writes a key/value pair, optionally expiring after ttl seconds",
+				Args:        []router.Arg{{Name: "key"}, {Name: "value"}, {Name: "ttl", Type: router.Int, Optional: true}},
+				Handler:     t.write,
+			}).
+			Invoke(router.Function{
+				Name:        "write_if",
+				Description: "Writes a key/value pair if the stored version matches, 0 meaning the key must not exist",
+				Args:        []router.Arg{{Name: "key"}, {Name: "value"}, {Name: "version", Type: router.Uint}},
+				Handler:     t.writeIf,
+			}).
+			Invoke(router.Function{
+				Name:        "delete",
+				Description: "Deletes a key, optionally leaving a tombstone",
+				Args:        []router.Arg{{Name: "key"}, {Name: "tombstone", Type: router.Bool, Optional: true}},
+				Handler:     t.delete,
+			}).
+			Invoke(router.Function{
+				Name:        "write_batch",
+				Description: "Writes a JSON array of {\"key\", \"value\"} objects all-or-nothing",
+				Args:        []router.Arg{{Name: "batch", Type: router.JSON}},
+				Handler:     t.writeBatch,
+			}).
+			Invoke(router.Function{
+				Name:        "delete_batch",
+				Description: "Deletes a JSON array of keys all-or-nothing",
+				Args:        []router.Arg{{Name: "keys", Type: router.JSON}},
+				Handler:     t.deleteBatch,
+			}).
+			Invoke(router.Function{
+				Name:        "purge_expired",
+				Description: "Deletes up to limit expired keys",
+				Args:        []router.Arg{{Name: "limit", Type: router.Int, Optional: true}},
+				Handler:     t.purgeExpired,
+			}).
+			Invoke(router.Function{
+				Name:        "incr",
+				Description: "Adds one to a counter and returns its new value",
+				Args:        []router.Arg{{Name: "key"}},
+				Handler:     t.incr,
+			}).
+			Invoke(router.Function{
+				Name:        "decr",
+				Description: "Subtracts one from a counter and returns its new value",
+				Args:        []router.Arg{{Name: "key"}},
+				Handler:     t.decr,
+			}).
+			Invoke(router.Function{
+				Name:        "add",
+				Description: "Adds delta to a counter and returns its new value",
+				Args:        []router.Arg{{Name: "key"}, {Name: "delta", Type: router.Int}},
+				Handler:     t.add,
+			}).
+			Invoke(router.Function{
+				Name:        "register_schema",
+				Description: "Requires the values of keys starting with prefix to validate under a JSON schema",
+				Args:        []router.Arg{{Name: "prefix"}, {Name: "schema", Type: router.JSON}},
+				Handler:     t.registerSchema,
+			}).
+			Invoke(router.Function{
+				Name:        "declare_index",
+				Description: "Indexes the keys starting with prefix by the field at a dot separated path of their JSON values",
+				Args:        []router.Arg{{Name: "prefix"}, {Name: "path"}},
+				Handler:     t.declareIndex,
+			}).
+			Invoke(router.Function{
+				Name:        "set_quota",
+				Description: "Sets the default quota, or the quota of the caller with the given certificate fingerprint; null restores the default",
+				Args:        []router.Arg{{Name: "quota", Type: router.JSON}, {Name: "caller", Optional: true}},
+				Handler:     t.setQuota,
+			}).
+			Invoke(router.Function{
+				Name:        "import",
+				Description: "Restores a page of a dump returned by export, the last page coming with the checksum of the whole dump",
+				Args:        []router.Arg{{Name: "dump"}, {Name: "checksum", Optional: true}},
+				Handler:     t.importDump,
+			}).
+			Invoke(router.Function{
+				Name:        "abort_import",
+				Description: "Discards the progress of an unfinished import",
+				Handler:     t.abortImport,
+			}).
+			Invoke(router.Function{
+				Name:        "write_from_remote",
+				Description: "Writes under key the result of a query of an allowlisted chaincode",
+				Args:        []router.Arg{{Name: "key"}, {Name: "chaincode"}, {Name: "function"}, {Name: "args", Variadic: true}},
+				Handler:     t.writeFromRemote,
+			}).
+			Query(router.Function{
+				Name:        "read",
+				Description: "Reads the value of a key",
+				Args:        []router.Arg{{Name: "key"}},
+				Handler:     t.read,
+			}).
+			Query(router.Function{
+				Name:        "read_versioned",
+				Description: "Reads the value of a key together with its version",
+				Args:        []router.Arg{{Name: "key"}},
+				Handler:     t.readVersioned,
+			}).
+			Query(router.Function{
+				Name:        "history",
+				Description: "Lists the changes of a key, newest first",
+				Args:        []router.Arg{{Name: "key"}, {Name: "limit", Type: router.Int, Optional: true}, {Name: "token", Optional: true}},
+				Handler:     t.history,
+			}).
+			Query(router.Function{
+				Name:        "list",
+				Description: "Lists the keys starting with prefix",
+				Args:        append([]router.Arg{{Name: "prefix"}}, pageArgs...),
+				Handler:     t.list,
+			}).
+			Query(router.Function{
+				Name:        "range",
+				Description: "Lists the keys between startKey (inclusive) and endKey (exclusive), an empty endKey meaning no bound",
+				Args:        append([]router.Arg{{Name: "startKey"}, {Name: "endKey"}}, pageArgs...),
+				Handler:     t.rangeKeys,
+			}).
+			Query(router.Function{
+				Name:        "export",
+				Description: "Returns a page of the dump of all the keys as JSON lines, ending with the next token and the checksum so far",
+				Args:        []router.Arg{{Name: "limit", Type: router.Int, Optional: true}, {Name: "token", Optional: true}},
+				Handler:     t.export,
+			}).
+			Query(router.Function{
+				Name:        "digest",
+				Description: "Returns the Merkle root over all the keys and values, in key order",
+				Handler:     t.digest,
+			}).
+			Query(router.Function{
+				Name:        "proof",
+				Description: "Returns the Merkle path proving that the value of a key is part of the digest",
+				Args:        []router.Arg{{Name: "key"}},
+				Handler:     t.proof,
+			}).
+			Query(router.Function{
+				Name:        "read_remote",
+				Description: "Returns the result of a query of an allowlisted chaincode",
+				Args:        []router.Arg{{Name: "chaincode"}, {Name: "function"}, {Name: "args", Variadic: true}},
+				Handler:     t.readRemote,
+			}).
+			Query(router.Function{
+				Name:        "usage",
+				Description: "Returns the keys and bytes owned by the caller with the given certificate fingerprint, by default the querier, and its quota",
+				Args:        []router.Arg{{Name: "caller", Optional: true}},
+				Handler:     t.callerUsage,
+			}).
+			Query(router.Function{
+				Name:        "find",
+				Description: "Lists the keys starting with prefix whose field at path holds value, a JSON scalar or a plain string",
+				Args:        append([]router.Arg{{Name: "prefix"}, {Name: "path"}, {Name: "value"}}, pageArgs...),
+				Handler:     t.find,
+			})
+	})
+	return t.routes
+}
+
 // Invoke isur entry point to invoke a chaincode function
-func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Invoke(stub compat.Stub, function string, args []string) ([]byte, error) {
 	fmt.Println("invoke is running " + function)
 
-	// Handle different functions
-	if function == "init" {
-		return t.Init(stub, "init", args)
-	} else if function == "write" {
-		return t.write(stub, args)
-	}
-	fmt.Println("invoke did not find func: " + function)
-
-	return nil, errors.New("Received unknown function invocation: " + function)
+	return t.functions().HandleInvoke(stub, function, args)
 }
 
 // Query is our entry point for queries
-func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Query(stub compat.Stub, function string, args []string) ([]byte, error) {
 	fmt.Println("query is running " + function)
 
-	// Handle different functions
-	if function == "read" { //read a variable
-		return t.read(stub, args)
-	}
-	fmt.Println("query did not find func: " + function)
+	return t.functions().HandleQuery(stub, function, args)
+}
 
-	return nil, errors.New("Received unknown function query: " + function)
+// IsQuery tells whether function is a query, for Fabric versions where queries go through Invoke
+func (t *SimpleChaincode) IsQuery(function string) bool {
+	return t.functions().IsQuery(function)
 }
 
-// write - invoke function to write key/value pair
-func (t *SimpleChaincode) write(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+// reinit - invoke function to reset hello_world
+func (t *SimpleChaincode) reinit(stub compat.Stub, args *router.Args) ([]byte, error) {
+	return writeKey(stub, "hello_world", []byte(args.String("value")), 0)
+}
+
+// write - invoke function to write key/value pair, optionally expiring after ttl seconds
+func (t *SimpleChaincode) write(stub compat.Stub, args *router.Args) ([]byte, error) {
 	var key, value string
+	var expiry int64
 	var err error
 	fmt.Println("running write()")
 
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2. name of the key and value to set")
+	key = args.String("key") //rename for funsies
+	value = args.String("value")
+	if args.Has("ttl") {
+		expiry, err = expiryFromTTL(stub, args.Int("ttl"))
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	key = args[0] //rename for funsies
-	value = args[1]
-	err = stub.PutState(key, []byte(value)) //write the variable into the chaincode state
+	return writeKey(stub, key, []byte(value), expiry) //write the variable into the chaincode state
+}
+
+// writeIf - invoke function to write key/value pair only if the stored version matches.
+// Version 0 means the key must not have been written yet.
+func (t *SimpleChaincode) writeIf(stub compat.Stub, args *router.Args) ([]byte, error) {
+	fmt.Println("running writeIf()")
+
+	key := args.String("key")
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := args.Uint("version")
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Version != expected {
+		jsonResp, _ := json.Marshal(versionConflict{Error: "version conflict", Key: key, Expected: expected, Actual: meta.Version})
+		return nil, errors.New(string(jsonResp))
+	}
+
+	return writeKey(stub, key, []byte(args.String("value")), 0)
+}
+
+// writeKey checks that the caller can write value under key, writes it and reports the change
+func writeKey(stub compat.Stub, key string, value []byte, expiry int64) ([]byte, error) {
+	err := checkWrite(stub, key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := setValue(stub, key, value, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitChanges(stub, change)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// delete - invoke function to delete a key/value pair, optionally leaving a tombstone
+func (t *SimpleChaincode) delete(stub compat.Stub, args *router.Args) ([]byte, error) {
+	fmt.Println("running delete()")
+
+	key := args.String("key")
+	err := checkDelete(stub, key)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := removeValue(stub, key, args.Bool("tombstone"))
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitChanges(stub, change)
 	if err != nil {
 		return nil, err
 	}
@@ -96,20 +404,142 @@ func (t *SimpleChaincode) write(stub shim.ChaincodeStubInterface, args []string)
 }
 
 // read - query function to read key/value pair
-func (t *SimpleChaincode) read(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var key, jsonResp string
+func (t *SimpleChaincode) read(stub compat.Stub, args *router.Args) ([]byte, error) {
+	var key string
 	var err error
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the key to query")
+	key = args.String("key")
+	err = validateKey(key)
+	if err != nil {
+		return nil, err
 	}
 
-	key = args[0]
-	valAsbytes, err := stub.GetState(key)
+	valAsbytes, _, err := getValue(stub, key)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + key + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
 	}
 
 	return valAsbytes, nil
 }
+
+// readVersioned - query function to read a key/value pair together with its version
+func (t *SimpleChaincode) readVersioned(stub compat.Stub, args *router.Args) ([]byte, error) {
+	key := args.String("key")
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	valAsbytes, meta, err := getValue(stub, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(versionedValue{Key: key, Value: string(valAsbytes), Version: meta.Version})
+}
+
+// list - query function to list the keys starting with a prefix
+func (t *SimpleChaincode) list(stub compat.Stub, args *router.Args) ([]byte, error) {
+	prefix := args.String("prefix")
+	return t.page(stub, prefix, prefix+lastKey, args)
+}
+
+// rangeKeys - query function to list the keys between startKey (inclusive) and endKey (exclusive).
+// An empty endKey lists everything after startKey.
+func (t *SimpleChaincode) rangeKeys(stub compat.Stub, args *router.Args) ([]byte, error) {
+	startKey, endKey := args.String("startKey"), args.String("endKey")
+	if endKey == "" {
+		endKey = lastKey
+	}
+	return t.page(stub, startKey, endKey, args)
+}
+
+// page returns the page of keys between startKey and endKey selected by the paging arguments as JSON
+func (t *SimpleChaincode) page(stub compat.Stub, startKey, endKey string, args *router.Args) ([]byte, error) {
+	limit, err := pageLimit(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.Has("token") {
+		next, err := base64.URLEncoding.DecodeString(args.String("token"))
+		if err != nil {
+			return nil, errors.New("Invalid continuation token")
+		}
+		if string(next) > startKey {
+			startKey = string(next)
+		}
+	}
+
+	result, err := t.scanKeys(stub, startKey, endKey, limit, args.Bool("values"))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// pageLimit returns the optional limit argument, defaultPageSize if omitted
+func pageLimit(args *router.Args) (int, error) {
+	if !args.Has("limit") {
+		return defaultPageSize, nil
+	}
+
+	limit := args.Int("limit")
+	if limit < 1 || limit > maxPageSize {
+		return 0, fmt.Errorf("Invalid limit [%d]. Expecting a number between 1 and %d", limit, maxPageSize)
+	}
+	return int(limit), nil
+}
+
+// scanKeys collects up to limit keys between startKey (inclusive) and endKey (exclusive)
+func (t *SimpleChaincode) scanKeys(stub compat.Stub, startKey, endKey string, limit int, withValues bool) (*keyPage, error) {
+	result := &keyPage{Results: []keyValue{}}
+	if startKey < firstKey {
+		// Never expose internal records
+		startKey = firstKey
+	}
+	if startKey >= endKey {
+		return result, nil
+	}
+
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed querying range [%s, %s): [%s]", startKey, endKey, err)
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed iterating range [%s, %s): [%s]", startKey, endKey, err)
+		}
+
+		// The Fabric 0.6 range query includes endKey
+		if key >= endKey {
+			continue
+		}
+		expired, err := isKeyExpired(stub, key)
+		if err != nil {
+			return nil, err
+		}
+		if expired {
+			continue
+		}
+
+		if len(result.Results) == limit {
+			// Resume right after the last returned key
+			last := result.Results[limit-1].Key
+			result.Next = base64.URLEncoding.EncodeToString([]byte(last + "\x00"))
+			break
+		}
+
+		entry := keyValue{Key: key}
+		if withValues {
+			v := string(value)
+			entry.Value = &v
+		}
+		result.Results = append(result.Results, entry)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,88 @@
+//go:build fabric1
+// +build fabric1
+
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/shiliy/learn-chaincode/compat"
+)
+
+// call runs function with args as transaction txID through the Fabric 1.x adapter and returns its payload
+func call(t *testing.T, stub *shim.MockStub, txID, function string, args ...string) []byte {
+	input := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+
+	var response pb.Response
+	if function == "init" {
+		response = stub.MockInit(txID, input)
+	} else {
+		response = stub.MockInvoke(txID, input)
+	}
+	if response.Status != shim.OK {
+		t.Fatalf("%s failed: %s", function, response.Message)
+	}
+	return response.Payload
+}
+
+// TestFabric1 checks that the functions scanning internal records work on the Fabric 1.x shim,
+// which only ranges over keys starting with NUL by partial composite key
+func TestFabric1(t *testing.T) {
+	stub := shim.NewMockStub("finished", compat.NewAdapter(new(SimpleChaincode)))
+
+	call(t, stub, "tx1", "init", "hi")
+	call(t, stub, "tx2", "write", "a", "1")
+	call(t, stub, "tx3", "write", "b", "2", "3600")
+	call(t, stub, "tx4", "write", "hello_world", "go away")
+
+	var page keyPage
+	err := json.Unmarshal(call(t, stub, "tx5", "range", "", ""), &page)
+	if err != nil {
+		t.Fatalf("Invalid range result: %s", err)
+	}
+	expected := []keyValue{{Key: "a"}, {Key: "b"}, {Key: "hello_world"}}
+	if !reflect.DeepEqual(page.Results, expected) {
+		t.Fatalf("range returned %+v, expected %+v", page.Results, expected)
+	}
+
+	var history historyPage
+	err = json.Unmarshal(call(t, stub, "tx6", "history", "hello_world"), &history)
+	if err != nil {
+		t.Fatalf("Invalid history result: %s", err)
+	}
+	if len(history.Entries) != 2 || history.Entries[0].Version != 2 {
+		t.Fatalf("history returned %+v, expected versions 2 and 1", history.Entries)
+	}
+
+	var digest stateDigest
+	err = json.Unmarshal(call(t, stub, "tx7", "digest"), &digest)
+	if err != nil {
+		t.Fatalf("Invalid digest result: %s", err)
+	}
+	if digest.LeafCount != 3 {
+		t.Fatalf("digest covers %d keys, expected 3", digest.LeafCount)
+	}
+}
@@ -0,0 +1,782 @@
+//go:build !fabric1
+// +build !fabric1
+
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shiliy/learn-chaincode/compat"
+)
+
+// eventStub is a mock stub recording the chaincode events set by the chaincode.
+// The caller both holds and signs with the certificate set by the test: the signature of a certificate is the
+// certificate itself. Queries of other chaincodes are answered by remotes.
+type eventStub struct {
+	*shim.MockStub
+	events  map[string][]byte
+	caller  []byte
+	now     int64
+	remotes map[string]func(args [][]byte) ([]byte, error)
+}
+
+func newEventStub(cc compat.LegacyChaincode) *eventStub {
+	return &eventStub{
+		MockStub: shim.NewMockStub("finished", compat.Wrap(cc)),
+		events:   make(map[string][]byte),
+		now:      1480000000,
+		remotes:  make(map[string]func(args [][]byte) ([]byte, error)),
+	}
+}
+
+func (s *eventStub) SetEvent(name string, payload []byte) error {
+	s.events[name] = payload
+	return nil
+}
+
+func (s *eventStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.now}, nil
+}
+
+func (s *eventStub) GetCallerCertificate() ([]byte, error) {
+	return s.caller, nil
+}
+
+func (s *eventStub) GetCallerMetadata() ([]byte, error) {
+	return s.caller, nil
+}
+
+func (s *eventStub) GetPayload() ([]byte, error) {
+	return nil, nil
+}
+
+func (s *eventStub) GetBinding() ([]byte, error) {
+	return nil, nil
+}
+
+func (s *eventStub) VerifySignature(certificate, signature, message []byte) (bool, error) {
+	return len(certificate) != 0 && bytes.Equal(certificate, signature), nil
+}
+
+func (s *eventStub) QueryChaincode(chaincodeName string, args [][]byte) ([]byte, error) {
+	remote, ok := s.remotes[chaincodeName]
+	if !ok {
+		return nil, errors.New("Unknown chaincode " + chaincodeName)
+	}
+	return remote(args)
+}
+
+// invoke runs function as transaction txID and returns the change event it set
+func (s *eventStub) invoke(t *testing.T, cc *SimpleChaincode, txID, function string, args ...string) changeEvent {
+	s.events = make(map[string][]byte)
+
+	s.MockTransactionStart(txID)
+	var err error
+	if function == "init" {
+		_, err = cc.Init(s, function, args)
+	} else {
+		_, err = cc.Invoke(s, function, args)
+	}
+	s.MockTransactionEnd(txID)
+	if err != nil {
+		t.Fatalf("%s failed: %s", function, err)
+	}
+
+	payload, ok := s.events[changeEventName]
+	if !ok {
+		t.Fatalf("%s did not set the %s event", function, changeEventName)
+	}
+
+	var event changeEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		t.Fatalf("Invalid %s payload [%s]: %s", changeEventName, string(payload), err)
+	}
+	return event
+}
+
+// try runs function as transaction txID signed by caller and returns its result, whether it fails or not
+func (s *eventStub) try(cc *SimpleChaincode, caller, txID, function string, args ...string) ([]byte, error) {
+	s.caller = []byte(caller)
+	s.MockTransactionStart(txID)
+	defer s.MockTransactionEnd(txID)
+
+	return cc.Invoke(s, function, args)
+}
+
+// query runs function and decodes its JSON result into result
+func (s *eventStub) query(t *testing.T, cc *SimpleChaincode, result interface{}, function string, args ...string) {
+	resultAsBytes, err := cc.Query(s, function, args)
+	if err != nil {
+		t.Fatalf("%s failed: %s", function, err)
+	}
+	if err := json.Unmarshal(resultAsBytes, result); err != nil {
+		t.Fatalf("Invalid %s result [%s]: %s", function, string(resultAsBytes), err)
+	}
+}
+
+// expectError fails the test unless err holds message
+func expectError(t *testing.T, function string, err error, message string) {
+	if err == nil || !strings.Contains(err.Error(), message) {
+		t.Fatalf("%s returned [%v], expected an error holding [%s]", function, err, message)
+	}
+}
+
+func hashOf(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestChangeEvents(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	event := stub.invoke(t, cc, "tx1", "init", "hi")
+	expected := changeEvent{TxID: "tx1", Changes: []keyChange{
+		{Key: "hello_world", Op: opWrite, ValueHash: hashOf("hi"), Version: 1},
+	}}
+	if !reflect.DeepEqual(event, expected) {
+		t.Fatalf("Unexpected init event %+v, expected %+v", event, expected)
+	}
+
+	event = stub.invoke(t, cc, "tx2", "write", "hello_world", "go away")
+	expected = changeEvent{TxID: "tx2", Changes: []keyChange{
+		{Key: "hello_world", Op: opWrite, ValueHash: hashOf("go away"), Version: 2},
+	}}
+	if !reflect.DeepEqual(event, expected) {
+		t.Fatalf("Unexpected write event %+v, expected %+v", event, expected)
+	}
+
+	event = stub.invoke(t, cc, "tx3", "write_batch", `[{"key":"a","value":"1"},{"key":"b","value":"2"}]`)
+	expected = changeEvent{TxID: "tx3", Changes: []keyChange{
+		{Key: "a", Op: opWrite, ValueHash: hashOf("1"), Version: 1},
+		{Key: "b", Op: opWrite, ValueHash: hashOf("2"), Version: 1},
+	}}
+	if !reflect.DeepEqual(event, expected) {
+		t.Fatalf("Unexpected write_batch event %+v, expected %+v", event, expected)
+	}
+
+	event = stub.invoke(t, cc, "tx4", "delete", "a")
+	expected = changeEvent{TxID: "tx4", Changes: []keyChange{
+		{Key: "a", Op: opDelete, Version: 2},
+	}}
+	if !reflect.DeepEqual(event, expected) {
+		t.Fatalf("Unexpected delete event %+v, expected %+v", event, expected)
+	}
+}
+
+// TestWithoutAdmin checks that nobody can export, import or set quotas when no administrator was set at deploy time
+func TestWithoutAdmin(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.invoke(t, cc, "tx1", "init", "hi")
+
+	_, err := cc.Query(stub, "export", nil)
+	if err == nil || !strings.Contains(err.Error(), "no administrator configured") {
+		t.Fatalf("export returned [%v], expected the no administrator error", err)
+	}
+
+	entry, _ := json.Marshal(dumpEntry{Key: "hello_world", Value: []byte("pwned"), Meta: keyMeta{Version: 1, Owner: []byte("mallory")}})
+	for _, call := range [][]string{{"import", string(entry) + "\n"}, {"abort_import"}, {"set_quota", `{"maxValueSize":1}`}} {
+		stub.MockTransactionStart("tx2")
+		_, err = cc.Invoke(stub, call[0], call[1:])
+		stub.MockTransactionEnd("tx2")
+		if err == nil || !strings.Contains(err.Error(), "no administrator configured") {
+			t.Fatalf("%s returned [%v], expected the no administrator error", call[0], err)
+		}
+	}
+
+	value, err := cc.Query(stub, "read", []string{"hello_world"})
+	if err != nil || string(value) != "hi" {
+		t.Fatalf("hello_world reads [%s] [%v], expected hi", value, err)
+	}
+
+	stub.invoke(t, cc, "tx3", "write", "hello_world", "still writable")
+}
+
+// TestProof checks that the proof of every key folds its read value up to the digest root
+func TestProof(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.invoke(t, cc, "tx1", "init", "hi")
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		stub.invoke(t, cc, "tx-"+key, "write", key, "value of "+key)
+	}
+
+	var digest stateDigest
+	digestAsBytes, err := cc.Query(stub, "digest", nil)
+	if err == nil {
+		err = json.Unmarshal(digestAsBytes, &digest)
+	}
+	if err != nil {
+		t.Fatalf("digest failed: %s", err)
+	}
+	if digest.LeafCount != 6 {
+		t.Fatalf("digest covers %d keys, expected 6", digest.LeafCount)
+	}
+
+	for _, key := range []string{"a", "b", "c", "d", "e", "hello_world"} {
+		value, err := cc.Query(stub, "read", []string{key})
+		if err != nil {
+			t.Fatalf("read %s failed: %s", key, err)
+		}
+
+		var proof inclusionProof
+		proofAsBytes, err := cc.Query(stub, "proof", []string{key})
+		if err == nil {
+			err = json.Unmarshal(proofAsBytes, &proof)
+		}
+		if err != nil {
+			t.Fatalf("proof %s failed: %s", key, err)
+		}
+
+		hash := leafHash(key, value)
+		for _, step := range proof.Path {
+			sibling, _ := hex.DecodeString(step.Hash)
+			if step.Position == "left" {
+				hash = internalHash(sibling, hash)
+			} else {
+				hash = internalHash(hash, sibling)
+			}
+		}
+		if hex.EncodeToString(hash) != digest.Root {
+			t.Errorf("proof of %s folds to %x, expected root %s", key, hash, digest.Root)
+		}
+	}
+}
+
+// collectKeys runs a listing query page by page, limit keys per page, and returns the keys of all the pages
+func (s *eventStub) collectKeys(t *testing.T, cc *SimpleChaincode, limit int, function string, args ...string) []string {
+	keys := []string{}
+	token := []string{}
+	for {
+		var page keyPage
+		s.query(t, cc, &page, function, append(append(args, strconv.Itoa(limit)), token...)...)
+		if len(page.Results) > limit {
+			t.Fatalf("%s returned %d keys, expected %d at most", function, len(page.Results), limit)
+		}
+		for _, result := range page.Results {
+			keys = append(keys, result.Key)
+		}
+		if page.Next == "" {
+			return keys
+		}
+		token = []string{page.Next}
+	}
+}
+
+// TestPaging checks that list and range return every key once across pages, endKey excluded
+func TestPaging(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.invoke(t, cc, "tx1", "init", "hi")
+	stub.invoke(t, cc, "tx2", "write_batch", `[{"key":"a","value":"1"},{"key":"b","value":"2"},{"key":"c","value":"3"},{"key":"d","value":"4"},{"key":"e","value":"5"}]`)
+
+	for limit := 1; limit <= 7; limit++ {
+		if keys := stub.collectKeys(t, cc, limit, "list", ""); !reflect.DeepEqual(keys, []string{"a", "b", "c", "d", "e", "hello_world"}) {
+			t.Fatalf("list by %d returned %v, expected every key", limit, keys)
+		}
+		if keys := stub.collectKeys(t, cc, limit, "range", "b", "d"); !reflect.DeepEqual(keys, []string{"b", "c"}) {
+			t.Fatalf("range [b, d) by %d returned %v, expected b and c", limit, keys)
+		}
+		if keys := stub.collectKeys(t, cc, limit, "range", "c", ""); !reflect.DeepEqual(keys, []string{"c", "d", "e", "hello_world"}) {
+			t.Fatalf("range [c, ) by %d returned %v, expected c and the keys after it", limit, keys)
+		}
+	}
+
+	var page keyPage
+	stub.query(t, cc, &page, "list", "hello", "10", "", "true")
+	if len(page.Results) != 1 || page.Results[0].Value == nil || *page.Results[0].Value != "hi" {
+		t.Fatalf("Unexpected list of hello with values %+v", page)
+	}
+}
+
+// TestWriteIf checks that write_if only writes over the expected version
+func TestWriteIf(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.invoke(t, cc, "tx1", "init", "hi")
+	stub.invoke(t, cc, "tx2", "write_if", "k", "first", "0")
+
+	for _, version := range []string{"0", "2"} {
+		_, err := stub.try(cc, "", "tx3", "write_if", "k", "second", version)
+		if err == nil {
+			t.Fatalf("write_if over version %s succeeded, expected a conflict", version)
+		}
+
+		var conflict versionConflict
+		if json.Unmarshal([]byte(err.Error()), &conflict) != nil || conflict.Error != "version conflict" || conflict.Actual != 1 {
+			t.Fatalf("write_if over version %s returned [%s], expected a conflict with version 1", version, err)
+		}
+	}
+
+	stub.invoke(t, cc, "tx4", "write_if", "k", "second", "1")
+
+	var value versionedValue
+	stub.query(t, cc, &value, "read_versioned", "k")
+	if value != (versionedValue{Key: "k", Value: "second", Version: 2}) {
+		t.Fatalf("Unexpected versioned value %+v, expected second at version 2", value)
+	}
+}
+
+// collectHistory pages through the history of key, limit entries per page, and returns the versions it lists
+func (s *eventStub) collectHistory(t *testing.T, cc *SimpleChaincode, limit int, key string) []uint64 {
+	versions := []uint64{}
+	args := []string{key, strconv.Itoa(limit)}
+	for {
+		var page historyPage
+		s.query(t, cc, &page, "history", args...)
+		if len(page.Entries) > limit {
+			t.Fatalf("history returned %d entries, expected %d at most", len(page.Entries), limit)
+		}
+		for _, entry := range page.Entries {
+			versions = append(versions, entry.Version)
+		}
+		if page.Next == "" {
+			return versions
+		}
+		args = []string{key, strconv.Itoa(limit), page.Next}
+	}
+}
+
+// TestHistory checks that history lists every change newest first across pages, whatever the versions
+func TestHistory(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.caller = []byte("admin")
+	stub.invoke(t, cc, "tx1", "init", "hi")
+	for i := 1; i <= 4; i++ {
+		stub.invoke(t, cc, "tx-"+strconv.Itoa(i), "write", "k", "v"+strconv.Itoa(i))
+	}
+	stub.invoke(t, cc, "tx5", "delete", "k")
+	stub.invoke(t, cc, "tx6", "write", "other", "x")
+
+	for limit := 1; limit <= 6; limit++ {
+		if versions := stub.collectHistory(t, cc, limit, "k"); !reflect.DeepEqual(versions, []uint64{5, 4, 3, 2, 1}) {
+			t.Fatalf("history by %d returned versions %v, expected 5 down to 1", limit, versions)
+		}
+	}
+
+	var page historyPage
+	stub.query(t, cc, &page, "history", "k", "1")
+	expected := historyEntry{Version: 5, PreviousValue: "v4", TxID: "tx5", Caller: hashOf("admin"), Timestamp: "2016-11-24T15:06:40Z", Deleted: true}
+	if len(page.Entries) != 1 || page.Entries[0] != expected {
+		t.Fatalf("Unexpected newest entry %+v, expected %+v", page.Entries, expected)
+	}
+
+	// History is paged by key, not by counting versions down
+	entry, _ := json.Marshal(dumpEntry{Key: "imported", Value: []byte("far"), Meta: keyMeta{Version: 1 << 62}})
+	stub.invoke(t, cc, "tx7", "import", string(entry))
+	stub.invoke(t, cc, "tx8", "write", "imported", "further")
+	if versions := stub.collectHistory(t, cc, 1, "imported"); !reflect.DeepEqual(versions, []uint64{1<<62 + 1, 1 << 62}) {
+		t.Fatalf("history of imported returned versions %v, expected the two imported ones", versions)
+	}
+}
+
+// snapshot copies the whole state of the stub
+func (s *eventStub) snapshot() map[string]string {
+	state := make(map[string]string, len(s.State))
+	for key, value := range s.State {
+		state[key] = string(value)
+	}
+	return state
+}
+
+// TestBatchAllOrNothing checks that a batch with a failing operation leaves the state untouched
+func TestBatchAllOrNothing(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.invoke(t, cc, "tx1", "init", "hi")
+	stub.caller = []byte("alice")
+	stub.invoke(t, cc, "tx2", "write", "a", "alice's")
+
+	before := stub.snapshot()
+	failing := [][]string{
+		{"write_batch", `[{"key":"b","value":"2"},{"key":"a","value":"bob's"}]`, "Invalid operation 1"},
+		{"write_batch", `[{"key":"b","value":"2"},{"key":"b","value":"3"}]`, "duplicate key [b]"},
+		{"write_batch", `[{"key":"b","value":"2"},{"key":"","value":"3"}]`, "Invalid operation 1"},
+		{"delete_batch", `["hello_world","a"]`, "not the owner of [a]"},
+		{"delete_batch", `["hello_world","missing"]`, "Key [missing] not found"},
+	}
+	for _, call := range failing {
+		stub.events = make(map[string][]byte)
+		_, err := stub.try(cc, "bob", "tx3", call[0], call[1])
+		expectError(t, call[0], err, call[2])
+
+		if after := stub.snapshot(); !reflect.DeepEqual(after, before) {
+			t.Fatalf("%s %s changed the state", call[0], call[1])
+		}
+		if len(stub.events) != 0 {
+			t.Fatalf("%s %s set events %v", call[0], call[1], stub.events)
+		}
+	}
+
+	stub.caller = []byte("bob")
+	stub.invoke(t, cc, "tx4", "write_batch", `[{"key":"b","value":"2"},{"key":"c","value":"3"}]`)
+	stub.invoke(t, cc, "tx5", "delete_batch", `["b","c","hello_world"]`)
+	if keys := stub.collectKeys(t, cc, 10, "list", ""); !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Fatalf("The batches left %v, expected a alone", keys)
+	}
+}
+
+// TestTombstone checks that reading a key deleted with a tombstone reports who deleted it, until it is written again
+func TestTombstone(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.invoke(t, cc, "tx1", "init", "hi")
+	stub.caller = []byte("alice")
+	stub.invoke(t, cc, "tx2", "write_batch", `[{"key":"kept","value":"1"},{"key":"gone","value":"2"}]`)
+	stub.invoke(t, cc, "tx3", "delete", "kept", "true")
+	stub.invoke(t, cc, "tx4", "delete", "gone")
+
+	_, err := cc.Query(stub, "read", []string{"kept"})
+	var deleted deletedError
+	if err == nil || json.Unmarshal([]byte(err.Error()), &deleted) != nil {
+		t.Fatalf("read of a tombstone returned [%v], expected the deleted error", err)
+	}
+	if deleted != (deletedError{Error: "deleted", Key: "kept", DeletedBy: hashOf("alice"), TxID: "tx3"}) {
+		t.Fatalf("Unexpected deleted error %+v", deleted)
+	}
+
+	value, err := cc.Query(stub, "read", []string{"gone"})
+	if err != nil || value != nil {
+		t.Fatalf("read of a deleted key returned [%s] [%v], expected nothing", value, err)
+	}
+
+	if keys := stub.collectKeys(t, cc, 10, "list", ""); !reflect.DeepEqual(keys, []string{"hello_world"}) {
+		t.Fatalf("list returned %v, expected the tombstone left out", keys)
+	}
+
+	stub.caller = []byte("bob")
+	stub.invoke(t, cc, "tx5", "write", "kept", "again")
+	value, err = cc.Query(stub, "read", []string{"kept"})
+	if err != nil || string(value) != "again" {
+		t.Fatalf("read of a rewritten tombstone returned [%s] [%v], expected again", value, err)
+	}
+}
+
+// TestExpiry checks that expired keys read as absent until purge_expired deletes them
+func TestExpiry(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.invoke(t, cc, "tx1", "init", "hi")
+	stub.invoke(t, cc, "tx2", "write", "short", "1", "10")
+	stub.invoke(t, cc, "tx3", "write", "shorter", "2", "5")
+	stub.invoke(t, cc, "tx4", "write", "long", "3", "100")
+
+	value, err := cc.Query(stub, "read", []string{"short"})
+	if err != nil || string(value) != "1" {
+		t.Fatalf("read of a live key returned [%s] [%v], expected 1", value, err)
+	}
+
+	stub.now += 10
+	value, err = cc.Query(stub, "read", []string{"short"})
+	if err != nil || value != nil {
+		t.Fatalf("read of an expired key returned [%s] [%v], expected nothing", value, err)
+	}
+	if keys := stub.collectKeys(t, cc, 1, "list", ""); !reflect.DeepEqual(keys, []string{"hello_world", "long"}) {
+		t.Fatalf("list returned %v, expected the expired keys left out", keys)
+	}
+
+	var digest stateDigest
+	stub.query(t, cc, &digest, "digest")
+	if digest.LeafCount != 2 {
+		t.Fatalf("digest covers %d keys, expected the 2 live ones", digest.LeafCount)
+	}
+
+	var purged purgeResult
+	for _, expected := range []purgeResult{{Purged: []string{"shorter"}, More: true}, {Purged: []string{"short"}}, {Purged: []string{}}} {
+		stub.MockTransactionStart("tx5")
+		result, err := cc.Invoke(stub, "purge_expired", []string{"1"})
+		stub.MockTransactionEnd("tx5")
+		if err == nil {
+			err = json.Unmarshal(result, &purged)
+		}
+		if err != nil || !reflect.DeepEqual(purged, expected) {
+			t.Fatalf("purge_expired returned %+v [%v], expected %+v", purged, err, expected)
+		}
+	}
+
+	for _, key := range []string{"short", "shorter", expiryKey(1480000010, "short"), expiryKey(1480000005, "shorter")} {
+		if _, ok := stub.State[key]; ok {
+			t.Fatalf("purge_expired left [%q] behind", key)
+		}
+	}
+}
+
+// TestOwnership checks that only the owner of a key or the administrator can overwrite or delete it
+func TestOwnership(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.caller = []byte("admin")
+	stub.invoke(t, cc, "tx1", "init", "hi")
+	stub.caller = []byte("alice")
+	stub.invoke(t, cc, "tx2", "write", "k", "alice's")
+	stub.invoke(t, cc, "tx3", "write", "e", "alice's", "5")
+
+	_, err := stub.try(cc, "bob", "tx4", "write", "k", "bob's")
+	expectError(t, "write", err, "not the owner of [k]")
+	_, err = stub.try(cc, "bob", "tx5", "delete", "k")
+	expectError(t, "delete", err, "not the owner of [k]")
+
+	stub.caller = []byte("admin")
+	stub.invoke(t, cc, "tx6", "write", "k", "admin's")
+	stub.caller = []byte("alice")
+	stub.invoke(t, cc, "tx7", "write", "k", "alice's again")
+
+	// Anyone can recreate an expired key, and owns it afterwards
+	stub.now += 5
+	stub.caller = []byte("bob")
+	stub.invoke(t, cc, "tx8", "write", "e", "bob's")
+	_, err = stub.try(cc, "alice", "tx9", "write", "e", "alice's")
+	expectError(t, "write", err, "not the owner of [e]")
+
+	stub.caller = []byte("admin")
+	stub.invoke(t, cc, "tx10", "delete", "k")
+	stub.invoke(t, cc, "tx11", "delete", "e")
+}
+
+// TestCounters checks that counters refuse to overflow and start over like write does
+func TestCounters(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.invoke(t, cc, "tx1", "init", "hi")
+
+	counter := func(txID, function string, args ...string) string {
+		value, err := stub.try(cc, "", txID, function, args...)
+		if err != nil {
+			t.Fatalf("%s %v failed: %s", function, args, err)
+		}
+		return string(value)
+	}
+
+	if value := counter("tx2", "incr", "c"); value != "1" {
+		t.Fatalf("incr of a missing key returned %s, expected 1", value)
+	}
+	if value := counter("tx3", "add", "c", "-3"); value != "-2" {
+		t.Fatalf("add -3 returned %s, expected -2", value)
+	}
+
+	stub.invoke(t, cc, "tx4", "write", "max", "9223372036854775807")
+	_, err := stub.try(cc, "", "tx5", "incr", "max")
+	expectError(t, "incr", err, "overflows")
+	stub.invoke(t, cc, "tx6", "write", "min", "-9223372036854775808")
+	_, err = stub.try(cc, "", "tx7", "decr", "min")
+	expectError(t, "decr", err, "overflows")
+	_, err = stub.try(cc, "", "tx8", "add", "min", "-9223372036854775808")
+	expectError(t, "add", err, "overflows")
+	if value := counter("tx9", "add", "min", "9223372036854775807"); value != "-1" {
+		t.Fatalf("add max to min returned %s, expected -1", value)
+	}
+
+	_, err = stub.try(cc, "", "tx10", "incr", "hello_world")
+	expectError(t, "incr", err, "not a decimal integer")
+
+	stub.invoke(t, cc, "tx11", "delete", "c", "true")
+	if value := counter("tx12", "incr", "c"); value != "1" {
+		t.Fatalf("incr of a tombstone returned %s, expected 1", value)
+	}
+}
+
+// TestRouterErrors checks that calls not matching the registered functions fail with the router errors
+func TestRouterErrors(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.invoke(t, cc, "tx1", "init", "hi")
+
+	cases := []struct {
+		function string
+		args     []string
+		want     string
+	}{
+		{"nope", nil, `{"Error":"Unknown function","function":"nope"}`},
+		{"read", []string{"hello_world"}, `{"Error":"Unknown function","function":"read"}`},
+		{"write", []string{"k"}, `{"Error":"Incorrect number of arguments","function":"write","usage":"write(key string, value string, [ttl int])"}`},
+		{"write", []string{"k", "v", "soon"}, `{"Error":"Invalid argument","function":"write","argument":"ttl","expected":"int","usage":"write(key string, value string, [ttl int])"}`},
+	}
+	for _, c := range cases {
+		_, err := stub.try(cc, "", "tx2", c.function, c.args...)
+		if err == nil || err.Error() != c.want {
+			t.Errorf("%s %v returned error %v, expected %s", c.function, c.args, err, c.want)
+		}
+	}
+
+	_, err := cc.Query(stub, "write", []string{"k", "v"})
+	if err == nil || err.Error() != `{"Error":"Unknown function","function":"write"}` {
+		t.Errorf("write was callable as a query: %v", err)
+	}
+
+	var description struct {
+		Invokes []struct{ Name string } `json:"invokes"`
+		Queries []struct{ Name string } `json:"queries"`
+	}
+	stub.query(t, cc, &description, "describe")
+	if len(description.Invokes) == 0 || len(description.Queries) == 0 {
+		t.Fatalf("describe returned %+v, expected the registered functions", description)
+	}
+}
+
+// TestRemoteAllowlist checks that only the chaincodes allowlisted at deploy time can be queried
+func TestRemoteAllowlist(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.MockTransactionStart("tx0")
+	_, err := cc.Init(stub, "init", []string{"hi", `[""]`})
+	stub.MockTransactionEnd("tx0")
+	expectError(t, "init", err, "Invalid chaincode allowlist")
+
+	stub.invoke(t, cc, "tx1", "init", "hi", `["prices"]`)
+	stub.remotes["prices"] = func(args [][]byte) ([]byte, error) {
+		return bytes.Join(args, []byte(" ")), nil
+	}
+	stub.remotes["secrets"] = func(args [][]byte) ([]byte, error) {
+		return []byte("leaked"), nil
+	}
+
+	value, err := cc.Query(stub, "read_remote", []string{"prices", "quote", "ACME", "EUR"})
+	if err != nil || string(value) != "quote ACME EUR" {
+		t.Fatalf("read_remote returned [%s] [%v], expected the query of prices", value, err)
+	}
+	_, err = cc.Query(stub, "read_remote", []string{"secrets", "dump"})
+	expectError(t, "read_remote", err, "Chaincode [secrets] is not allowed")
+
+	stub.invoke(t, cc, "tx2", "write_from_remote", "acme", "prices", "quote", "ACME")
+	value, err = cc.Query(stub, "read", []string{"acme"})
+	if err != nil || string(value) != "quote ACME" {
+		t.Fatalf("read of the remote value returned [%s] [%v], expected quote ACME", value, err)
+	}
+	_, err = stub.try(cc, "", "tx3", "write_from_remote", "leak", "secrets", "dump")
+	expectError(t, "write_from_remote", err, "Chaincode [secrets] is not allowed")
+
+	// Without an allowlist, no chaincode can be queried
+	stub = newEventStub(cc)
+	stub.remotes["prices"] = func(args [][]byte) ([]byte, error) {
+		return []byte("1"), nil
+	}
+	stub.invoke(t, cc, "tx4", "init", "hi")
+	_, err = cc.Query(stub, "read_remote", []string{"prices", "quote"})
+	expectError(t, "read_remote", err, "Chaincode [prices] is not allowed")
+}
+
+// TestQuota checks that writes and imports beyond the quota of the owner are rejected
+func TestQuota(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.caller = []byte("admin")
+	stub.invoke(t, cc, "tx1", "init", "hi")
+	_, err := stub.try(cc, "alice", "tx2", "set_quota", `{"maxKeys":10}`)
+	expectError(t, "set_quota", err, "not an administrator")
+	if _, err = stub.try(cc, "admin", "tx3", "set_quota", `{"maxValueSize":5,"maxKeyLength":3,"maxKeys":2}`); err != nil {
+		t.Fatalf("set_quota failed: %s", err)
+	}
+
+	stub.caller = []byte("alice")
+	stub.invoke(t, cc, "tx4", "write", "a", "12345")
+	_, err = stub.try(cc, "alice", "tx5", "write", "a", "123456")
+	expectError(t, "write", err, "Quota exceeded. The value of [a] is larger than 5 bytes")
+	_, err = stub.try(cc, "alice", "tx6", "write", "long", "1")
+	expectError(t, "write", err, "Quota exceeded. Key [long] is longer than 3 bytes")
+	stub.invoke(t, cc, "tx7", "write", "b", "1")
+	_, err = stub.try(cc, "alice", "tx8", "write", "c", "1")
+	expectError(t, "write", err, "Quota exceeded. The caller already owns 2 keys")
+
+	if _, err = stub.try(cc, "admin", "tx9", "set_quota", `{"maxKeys":3}`, hashOf("alice")); err != nil {
+		t.Fatalf("set_quota of alice failed: %s", err)
+	}
+	stub.caller = []byte("alice")
+	stub.invoke(t, cc, "tx10", "write", "c", "1")
+
+	var report usageReport
+	stub.query(t, cc, &report, "usage")
+	expected := usageReport{Caller: hashOf("alice"), Usage: usage{Keys: 3, Bytes: 7}, Quota: quota{MaxKeys: 3}}
+	if report != expected {
+		t.Fatalf("Unexpected usage %+v, expected %+v", report, expected)
+	}
+
+	// Imported values are held to the quota of their owner too
+	entry, _ := json.Marshal(dumpEntry{Key: "d", Value: []byte("too large"), Meta: keyMeta{Version: 1, Owner: []byte("bob")}})
+	_, err = stub.try(cc, "admin", "tx11", "import", string(entry))
+	expectError(t, "import", err, "Quota exceeded. The value of [d] is larger than 5 bytes")
+}
+
+// TestFind checks that find pages through the indexed keys and leaves the expired ones out
+func TestFind(t *testing.T) {
+	cc := new(SimpleChaincode)
+	stub := newEventStub(cc)
+
+	stub.caller = []byte("admin")
+	stub.invoke(t, cc, "tx1", "init", "hi")
+	stub.invoke(t, cc, "tx2", "write", "u1", `{"city":"paris"}`)
+	stub.invoke(t, cc, "tx3", "write", "u2", `{"city":"paris"}`, "5")
+	stub.invoke(t, cc, "tx4", "write", "u3", `{"city":"paris"}`)
+	stub.invoke(t, cc, "tx5", "write", "u9", `{"city":"rome"}`)
+	stub.now += 5
+
+	_, err := stub.try(cc, "alice", "tx6", "declare_index", "u", "city")
+	expectError(t, "declare_index", err, "not an administrator")
+	if _, err = stub.try(cc, "admin", "tx7", "declare_index", "u", "city"); err != nil {
+		t.Fatalf("declare_index failed: %s", err)
+	}
+	if entry := stub.State[indexEntryKey("u", "city", searchRepr("paris"), "u2")]; entry != nil {
+		t.Fatalf("declare_index indexed the expired key u2")
+	}
+
+	stub.caller = []byte("admin")
+	stub.invoke(t, cc, "tx8", "write", "u4", `{"city":"paris"}`)
+	stub.invoke(t, cc, "tx9", "write", "u5", `{"city":"paris"}`, "5")
+	stub.now += 5
+
+	for limit := 1; limit <= 4; limit++ {
+		if keys := stub.collectKeys(t, cc, limit, "find", "u", "city", "paris"); !reflect.DeepEqual(keys, []string{"u1", "u3", "u4"}) {
+			t.Fatalf("find returned %v with limit %d, expected [u1 u3 u4]", keys, limit)
+		}
+	}
+
+	// The expired key past the last live one must not cost an empty page
+	var page keyPage
+	stub.query(t, cc, &page, "find", "u", "city", "paris", "3")
+	if len(page.Results) != 3 || page.Next != "" {
+		t.Fatalf("find returned %+v, expected the 3 live keys and no continuation", page)
+	}
+
+	_, err = cc.Query(stub, "find", []string{"u", "name", "paris"})
+	expectError(t, "find", err, "No index on [name] for prefix [u]")
+}
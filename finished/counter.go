@@ -0,0 +1,105 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// incr - invoke function to add one to a counter and return its new value
+func (t *SimpleChaincode) incr(stub compat.Stub, args *router.Args) ([]byte, error) {
+	return t.addToCounter(stub, args.String("key"), 1)
+}
+
+// decr - invoke function to subtract one from a counter and return its new value
+func (t *SimpleChaincode) decr(stub compat.Stub, args *router.Args) ([]byte, error) {
+	return t.addToCounter(stub, args.String("key"), -1)
+}
+
+// add - invoke function to add a signed delta to a counter and return its new value
+func (t *SimpleChaincode) add(stub compat.Stub, args *router.Args) ([]byte, error) {
+	return t.addToCounter(stub, args.String("key"), args.Int("delta"))
+}
+
+// addToCounter adds delta to the decimal integer stored under key. As write does, it creates a missing,
+// deleted or expired key, which counts as 0. An empty value counts as 0 too, since Fabric 1.x stores
+// an empty value as a deletion.
+func (t *SimpleChaincode) addToCounter(stub compat.Stub, key string, delta int64) ([]byte, error) {
+	fmt.Println("running addToCounter()")
+
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	valAsbytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting state for [%s]: [%s]", key, err)
+	}
+
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return nil, err
+	}
+
+	expired, err := isExpired(stub, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the expiry of a live counter, an expired one starts over
+	var expiry int64
+	if expired {
+		valAsbytes = nil
+	} else if valAsbytes != nil {
+		expiry = meta.Expiry
+	}
+
+	var current int64
+	if len(valAsbytes) != 0 {
+		current, err = strconv.ParseInt(string(valAsbytes), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Value of [%s] is not a decimal integer: [%s]", key, string(valAsbytes))
+		}
+	}
+
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return nil, fmt.Errorf("Counter [%s] overflows adding %d to %d", key, delta, current)
+	}
+
+	value := []byte(strconv.FormatInt(current+delta, 10))
+	err = checkWrite(stub, key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := setValue(stub, key, value, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitChanges(stub, change)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,365 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// A dump is the sequence of JSON lines returned by successive export pages, one dumpEntry per live key
+// in key order. Each page ends with a dumpTrailer line holding the token of the next page and the
+// checksum of the dump so far: starting from 32 zero bytes, every entry line chains
+// h = SHA-256(h || line). The checksum of the trailer of the last page covers the whole dump.
+//
+// Feeding the pages, trailers included, to import in order restores the keys. The last import call
+// also passes that final checksum, and fails without committing anything unless it matches.
+
+// importKey holds the progress of an import spanning several transactions
+var importKey = internalKey(configKind, "import")
+
+// dumpEntry is a line of a dump. Values and owners are base64 encoded by encoding/json.
+type dumpEntry struct {
+	Key   string  `json:"key"`
+	Value []byte  `json:"value"`
+	Meta  keyMeta `json:"meta"`
+}
+
+// dumpTrailer is the last line of an export page.
+// Next is the continuation token for the following page, empty on the last page.
+type dumpTrailer struct {
+	Next     string `json:"next,omitempty"`
+	Checksum string `json:"checksum"`
+	Count    int    `json:"count"`
+}
+
+// dumpCursor is the decoded continuation token of export
+type dumpCursor struct {
+	Start    string `json:"start"`
+	Checksum string `json:"checksum"`
+}
+
+// importState is the progress of an import: the checksum of the lines imported so far
+type importState struct {
+	Checksum string `json:"checksum"`
+	Count    int    `json:"count"`
+}
+
+// importResult is the JSON document returned by import
+type importResult struct {
+	Imported int    `json:"imported"`
+	Total    int    `json:"total"`
+	Checksum string `json:"checksum"`
+	Done     bool   `json:"done"`
+}
+
+// initialChecksum is the checksum of an empty dump
+var initialChecksum = hex.EncodeToString(make([]byte, sha256.Size))
+
+// chainChecksum extends the hex checksum h with line
+func chainChecksum(h string, line []byte) (string, error) {
+	previous, err := hex.DecodeString(h)
+	if err != nil || len(previous) != sha256.Size {
+		return "", fmt.Errorf("Invalid checksum [%s]", h)
+	}
+
+	hash := sha256.Sum256(append(previous, line...))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// export - query function returning a page of the dump of all the live keys. Only the administrator can export.
+func (t *SimpleChaincode) export(stub compat.Stub, args *router.Args) ([]byte, error) {
+	err := checkAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := pageLimit(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := dumpCursor{Start: firstKey, Checksum: initialChecksum}
+	if args.Has("token") {
+		token, err := base64.URLEncoding.DecodeString(args.String("token"))
+		if err == nil {
+			err = json.Unmarshal(token, &cursor)
+		}
+		if err != nil || cursor.Start < firstKey {
+			return nil, errors.New("Invalid continuation token")
+		}
+	}
+
+	iter, err := stub.RangeQueryState(cursor.Start, lastKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed querying keys: [%s]", err)
+	}
+	defer iter.Close()
+
+	var page bytes.Buffer
+	trailer := dumpTrailer{Checksum: cursor.Checksum}
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed iterating keys: [%s]", err)
+		}
+		expired, err := isKeyExpired(stub, key)
+		if err != nil {
+			return nil, err
+		}
+		if expired {
+			continue
+		}
+
+		if trailer.Count == limit {
+			// Resume at the first key left out
+			next, _ := json.Marshal(dumpCursor{Start: key, Checksum: trailer.Checksum})
+			trailer.Next = base64.URLEncoding.EncodeToString(next)
+			break
+		}
+
+		meta, err := getMeta(stub, key)
+		if err != nil {
+			return nil, err
+		}
+		meta.Tombstone = nil
+
+		line, err := json.Marshal(dumpEntry{Key: key, Value: value, Meta: *meta})
+		if err != nil {
+			return nil, fmt.Errorf("Failed encoding [%s]: [%s]", key, err)
+		}
+		trailer.Checksum, err = chainChecksum(trailer.Checksum, line)
+		if err != nil {
+			return nil, err
+		}
+
+		page.Write(line)
+		page.WriteByte('\n')
+		trailer.Count++
+	}
+
+	line, err := json.Marshal(trailer)
+	if err != nil {
+		return nil, err
+	}
+	page.Write(line)
+	page.WriteByte('\n')
+	return page.Bytes(), nil
+}
+
+// importDump - invoke function restoring a page of a dump. Only the administrator can import.
+// The last page must come with the checksum of the whole dump, which is verified before anything of that page is stored.
+func (t *SimpleChaincode) importDump(stub compat.Stub, args *router.Args) ([]byte, error) {
+	fmt.Println("running importDump()")
+
+	err := checkAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	state := importState{Checksum: initialChecksum}
+	stateAsBytes, err := stub.GetState(importKey)
+	if err != nil {
+		return nil, errors.New("Failed fetching import progress")
+	}
+	if len(stateAsBytes) != 0 {
+		err = json.Unmarshal(stateAsBytes, &state)
+		if err != nil {
+			return nil, fmt.Errorf("Failed decoding import progress: [%s]", err)
+		}
+	}
+
+	// Validate the whole page before touching the state
+	lines := strings.Split(strings.TrimRight(args.String("dump"), "\n"), "\n")
+	entries := make([]dumpEntry, 0, len(lines))
+	for i, line := range lines {
+		var entry struct {
+			dumpEntry
+			Checksum *string `json:"checksum"`
+		}
+		err = json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid line %d: [%s]", i, err)
+		}
+
+		if entry.Checksum != nil {
+			// Export trailers carry the checksum, they are not part of the dump
+			if i != len(lines)-1 {
+				return nil, fmt.Errorf("Invalid line %d: trailer before the end of the page", i)
+			}
+			continue
+		}
+
+		err = validateKey(entry.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid line %d: %s", i, err)
+		}
+		if entry.Value == nil || entry.Meta.Version == 0 {
+			return nil, fmt.Errorf("Invalid line %d: missing value or version for [%s]", i, entry.Key)
+		}
+		if entry.Meta.Version == math.MaxUint64 {
+			return nil, fmt.Errorf("Invalid line %d: version of [%s] leaves no room for later writes", i, entry.Key)
+		}
+
+		state.Checksum, err = chainChecksum(state.Checksum, []byte(line))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry.dumpEntry)
+	}
+	if len(entries) > maxBatchSize {
+		return nil, fmt.Errorf("Invalid page size %d. Expecting at most %d keys", len(entries), maxBatchSize)
+	}
+	state.Count += len(entries)
+
+	done := args.Has("checksum")
+	if done && args.String("checksum") != state.Checksum {
+		return nil, fmt.Errorf("Checksum mismatch. Expecting [%s], the dump hashes to [%s]", args.String("checksum"), state.Checksum)
+	}
+
+	changes := make([]keyChange, 0, len(entries))
+	for _, entry := range entries {
+		change, err := restoreValue(stub, entry)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	if done {
+		err = stub.DelState(importKey)
+	} else {
+		stateAsBytes, _ = json.Marshal(state)
+		err = stub.PutState(importKey, stateAsBytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed storing import progress: [%s]", err)
+	}
+
+	err = emitChanges(stub, changes...)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(importResult{Imported: len(entries), Total: state.Count, Checksum: state.Checksum, Done: done})
+}
+
+// abortImport - invoke function discarding the progress of an unfinished import, so that a new one can start.
+// Keys already imported are kept.
+func (t *SimpleChaincode) abortImport(stub compat.Stub, args *router.Args) ([]byte, error) {
+	err := checkAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.DelState(importKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed removing import progress: [%s]", err)
+	}
+	return nil, nil
+}
+
+// restoreValue stores a dumped key with its owner and expiry, overwriting the current one.
+// The key keeps its dumped version, or the next one if it was already written at that version or after.
+// The quota of the dumped owner and the schema of the key apply as they do to writes.
+func restoreValue(stub compat.Stub, entry dumpEntry) (keyChange, error) {
+	key := entry.Key
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	previous, err := stub.GetState(key)
+	if err != nil {
+		return keyChange{}, fmt.Errorf("Failed getting state for [%s]: [%s]", key, err)
+	}
+
+	// The dumped owner is held to its quota and the value to its schema, as if the owner wrote it
+	err = checkSizeQuota(stub, fingerprint(entry.Meta.Owner), key, entry.Value)
+	if err != nil {
+		return keyChange{}, err
+	}
+	if previous == nil || !bytes.Equal(meta.Owner, entry.Meta.Owner) {
+		err = checkKeyQuota(stub, entry.Meta.Owner)
+		if err != nil {
+			return keyChange{}, err
+		}
+	}
+	err = checkSchema(stub, key, entry.Value)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	// Move the key from the usage of its current owner to the usage of the dumped one
+	if previous != nil {
+		err = trackUsage(stub, meta.Owner, -1, -int64(len(previous)))
+		if err != nil {
+			return keyChange{}, err
+		}
+	}
+	err = trackUsage(stub, entry.Meta.Owner, 1, int64(len(entry.Value)))
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	// Keep the dumped version unless the key already went past it here: versions only grow,
+	// so the history already recorded for the key is never overwritten
+	version := entry.Meta.Version
+	if version <= meta.Version {
+		version = meta.Version + 1
+	}
+
+	meta.Version = version
+	meta.Owner = entry.Meta.Owner
+	meta.Tombstone = nil
+
+	err = stub.PutState(key, entry.Value)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = updateIndexes(stub, key, previous, entry.Value)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = setExpiry(stub, key, meta, entry.Meta.Expiry)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = recordHistory(stub, key, meta.Version, previous, entry.Value, false)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = putMeta(stub, key, meta)
+	if err != nil {
+		return keyChange{}, err
+	}
+	return writeChange(key, entry.Value, meta.Version), nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/shiliy/learn-chaincode/compat"
+)
+
+// changeEventName is the name of the chaincode event set by every transaction changing keys.
+// Its payload is a changeEvent encoded as JSON, for example
+//
+//	{"txID":"...","changes":[{"key":"hello_world","op":"write","valueHash":"<hex SHA-256 of the value>","version":2}]}
+//
+// A transaction sets a single event, so batches report all their changes at once.
+const changeEventName = "kv_change"
+
+// Operations reported in a keyChange
+const (
+	opWrite  = "write"
+	opDelete = "delete"
+)
+
+// keyChange describes the change of a single key
+type keyChange struct {
+	Key       string `json:"key"`
+	Op        string `json:"op"`
+	ValueHash string `json:"valueHash,omitempty"` // hex SHA-256 of the new value, empty for deletions
+	Version   uint64 `json:"version"`
+}
+
+// changeEvent is the payload of the kv_change chaincode event
+type changeEvent struct {
+	TxID    string      `json:"txID"`
+	Changes []keyChange `json:"changes"`
+}
+
+// writeChange describes the write of value under key at the given version
+func writeChange(key string, value []byte, version uint64) keyChange {
+	hash := sha256.Sum256(value)
+	return keyChange{Key: key, Op: opWrite, ValueHash: hex.EncodeToString(hash[:]), Version: version}
+}
+
+// deleteChange describes the deletion of key at the given version
+func deleteChange(key string, version uint64) keyChange {
+	return keyChange{Key: key, Op: opDelete, Version: version}
+}
+
+// emitChanges sets the kv_change event of the transaction, if any key changed
+func emitChanges(stub compat.Stub, changes ...keyChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(changeEvent{TxID: stub.GetTxID(), Changes: changes})
+	if err != nil {
+		return fmt.Errorf("Failed encoding change event: [%s]", err)
+	}
+
+	err = stub.SetEvent(changeEventName, payload)
+	if err != nil {
+		return fmt.Errorf("Failed setting change event: [%s]", err)
+	}
+	return nil
+}
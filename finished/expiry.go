@@ -0,0 +1,159 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// purgeResult is the JSON document returned by purge_expired.
+// More reports that expired keys are left for a further call.
+type purgeResult struct {
+	Purged []string `json:"purged"`
+	More   bool     `json:"more"`
+}
+
+// expiryKey is the state key indexing key by its expiry, so expired keys can be found in order
+func expiryKey(expiry int64, key string) string {
+	return internalKey(expiryKind, fmt.Sprintf("%020d", expiry), key)
+}
+
+// expiryFromTTL converts a ttl in seconds to an expiry relative to the transaction timestamp
+func expiryFromTTL(stub compat.Stub, ttl int64) (int64, error) {
+	if ttl <= 0 {
+		return 0, fmt.Errorf("Invalid ttl [%d]. Expecting a positive number of seconds", ttl)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return 0, err
+	}
+	return now.Unix() + ttl, nil
+}
+
+// setExpiry replaces the expiry of key recorded in meta, keeping the expiry index in sync
+func setExpiry(stub compat.Stub, key string, meta *keyMeta, expiry int64) error {
+	if meta.Expiry == expiry {
+		return nil
+	}
+
+	if meta.Expiry != 0 {
+		err := stub.DelState(expiryKey(meta.Expiry, key))
+		if err != nil {
+			return fmt.Errorf("Failed removing expiry of [%s]: [%s]", key, err)
+		}
+	}
+	if expiry != 0 {
+		err := stub.PutState(expiryKey(expiry, key), []byte(key))
+		if err != nil {
+			return fmt.Errorf("Failed storing expiry of [%s]: [%s]", key, err)
+		}
+	}
+
+	meta.Expiry = expiry
+	return nil
+}
+
+// isExpired tells whether the key with bookkeeping meta expired at or before the transaction timestamp
+func isExpired(stub compat.Stub, meta *keyMeta) (bool, error) {
+	if meta.Expiry == 0 {
+		return false, nil
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return false, err
+	}
+	return now.Unix() >= meta.Expiry, nil
+}
+
+// scanExpired returns up to limit keys that expired at or before the transaction timestamp,
+// and whether more remain. A limit of 0 returns them all.
+func scanExpired(stub compat.Stub, limit int) ([]string, bool, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, false, err
+	}
+
+	startKey := internalKey(expiryKind)
+	endKey := internalKey(expiryKind, fmt.Sprintf("%020d", now.Unix()+1))
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed querying expired keys: [%s]", err)
+	}
+	defer iter.Close()
+
+	keys := []string{}
+	for iter.HasNext() {
+		if limit > 0 && len(keys) == limit {
+			return keys, true, nil
+		}
+
+		indexKey, _, err := iter.Next()
+		if err != nil {
+			return nil, false, fmt.Errorf("Failed iterating expired keys: [%s]", err)
+		}
+		keys = append(keys, indexKey[strings.LastIndex(indexKey, internalKeyPrefix)+1:])
+	}
+	return keys, false, nil
+}
+
+// isKeyExpired tells whether key expired but was not purged yet
+func isKeyExpired(stub compat.Stub, key string) (bool, error) {
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return false, err
+	}
+	return isExpired(stub, meta)
+}
+
+// purgeExpired - invoke function to delete up to limit expired keys.
+// Call it repeatedly while the result reports more.
+func (t *SimpleChaincode) purgeExpired(stub compat.Stub, args *router.Args) ([]byte, error) {
+	fmt.Println("running purgeExpired()")
+
+	limit, err := pageLimit(args)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, more, err := scanExpired(stub, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]keyChange, 0, len(keys))
+	for _, key := range keys {
+		change, err := removeValue(stub, key, false)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	err = emitChanges(stub, changes...)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(purgeResult{Purged: keys, More: more})
+}
@@ -0,0 +1,190 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// historyEntry is one change of a key, stored under its new version
+type historyEntry struct {
+	Version       uint64 `json:"version"`
+	PreviousValue string `json:"previousValue"`
+	NewValue      string `json:"newValue"`
+	TxID          string `json:"txID"`
+	Caller        string `json:"caller"` // hex SHA-256 fingerprint of the caller certificate
+	Timestamp     string `json:"timestamp"`
+	Deleted       bool   `json:"deleted,omitempty"`
+}
+
+// historyPage is the JSON document returned by the history query.
+// Next is the version to pass as token for the following page, empty on the last page.
+type historyPage struct {
+	Key     string         `json:"key"`
+	Entries []historyEntry `json:"entries"`
+	Next    string         `json:"next,omitempty"`
+}
+
+// historyKey is the state key of the history entry recording the given version of key
+func historyKey(key string, version uint64) string {
+	return internalKey(historyKind, key, fmt.Sprintf("%020d", version))
+}
+
+// callerFingerprint returns the hex SHA-256 of the caller certificate,
+// or an empty string when the transaction carries no certificate
+func callerFingerprint(stub compat.Stub) (string, error) {
+	cert, err := stub.GetCallerCertificate()
+	if err != nil {
+		return "", fmt.Errorf("Failed getting caller certificate: [%s]", err)
+	}
+	return fingerprint(cert), nil
+}
+
+// fingerprint returns the hex SHA-256 of cert, or an empty string for an empty certificate
+func fingerprint(cert []byte) string {
+	if len(cert) == 0 {
+		return ""
+	}
+
+	hash := sha256.Sum256(cert)
+	return hex.EncodeToString(hash[:])
+}
+
+// txTime returns the timestamp of the current transaction
+func txTime(stub compat.Stub) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed getting transaction timestamp: [%s]", err)
+	}
+	if ts == nil {
+		return time.Time{}, errors.New("Failed getting transaction timestamp. Nil")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// recordHistory appends the change of key from previous to value at the given version
+func recordHistory(stub compat.Stub, key string, version uint64, previous, value []byte, deleted bool) error {
+	caller, err := callerFingerprint(stub)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+
+	entryAsBytes, err := json.Marshal(historyEntry{
+		Version:       version,
+		PreviousValue: string(previous),
+		NewValue:      string(value),
+		TxID:          stub.GetTxID(),
+		Caller:        caller,
+		Timestamp:     now.Format(time.RFC3339Nano),
+		Deleted:       deleted,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed encoding history of [%s]: [%s]", key, err)
+	}
+
+	err = stub.PutState(historyKey(key, version), entryAsBytes)
+	if err != nil {
+		return fmt.Errorf("Failed storing history of [%s]: [%s]", key, err)
+	}
+	return nil
+}
+
+// history - query function to read the changes of a key, newest first
+func (t *SimpleChaincode) history(stub compat.Stub, args *router.Args) ([]byte, error) {
+	key := args.String("key")
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := pageLimit(args)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return nil, err
+	}
+
+	version := meta.Version
+	if args.Has("token") {
+		from, err := strconv.ParseUint(args.String("token"), 10, 64)
+		if err != nil {
+			return nil, errors.New("Invalid continuation token")
+		}
+		if from < version {
+			version = from
+		}
+	}
+
+	// History keys sort by version: keep the newest limit+1 entries up to version,
+	// the extra one starts the next page
+	iter, err := stub.RangeQueryState(internalKey(historyKind, key, ""), internalKey(historyKind, key, lastKey))
+	if err != nil {
+		return nil, fmt.Errorf("Failed querying history of [%s]: [%s]", key, err)
+	}
+	defer iter.Close()
+
+	last := historyKey(key, version)
+	window := [][]byte{}
+	for iter.HasNext() {
+		entryKey, entryAsBytes, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed iterating history of [%s]: [%s]", key, err)
+		}
+		if entryKey > last {
+			break
+		}
+
+		window = append(window, entryAsBytes)
+		if len(window) > limit+1 {
+			window = window[1:]
+		}
+	}
+
+	result := historyPage{Key: key, Entries: []historyEntry{}}
+	for i := len(window) - 1; i >= 0; i-- {
+		var entry historyEntry
+		err = json.Unmarshal(window[i], &entry)
+		if err != nil {
+			return nil, fmt.Errorf("Failed decoding history of [%s]: [%s]", key, err)
+		}
+
+		if len(result.Entries) == limit {
+			result.Next = strconv.FormatUint(entry.Version, 10)
+			break
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+
+	return json.Marshal(result)
+}
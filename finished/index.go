@@ -0,0 +1,290 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// indexDecl is an index declared on the field at Path of the JSON values of keys starting with Prefix.
+// Path is a dot separated list of object members, such as "customer.id".
+type indexDecl struct {
+	Prefix string `json:"prefix"`
+	Path   string `json:"path"`
+}
+
+// indexDeclKey is the state key of the declaration of the index on path for prefix
+func indexDeclKey(prefix, path string) string {
+	return internalKey(indexKind, prefix, path)
+}
+
+// indexEntryKey is the state key of the index entry of key, whose field at path holds the value represented by repr
+func indexEntryKey(prefix, path, repr, key string) string {
+	return internalKey(indexEntryKind, prefix, path, repr, key)
+}
+
+// fieldRepr returns the representation of the scalar at path in the JSON document value.
+// Strings, numbers, booleans and null are represented by their JSON encoding; the second
+// result is false when value is not JSON or holds no scalar at path.
+func fieldRepr(value []byte, path string) (string, bool) {
+	var doc interface{}
+	err := json.Unmarshal(value, &doc)
+	if err != nil {
+		return "", false
+	}
+
+	for _, member := range strings.Split(path, ".") {
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		doc, ok = object[member]
+		if !ok {
+			return "", false
+		}
+	}
+
+	switch doc.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", false
+	}
+	repr, err := json.Marshal(doc)
+	if err != nil {
+		return "", false
+	}
+	return string(repr), true
+}
+
+// searchRepr returns the representation of a value passed to find: a JSON scalar is taken as is,
+// anything else as a string, so that both open and "open" find the string "open"
+func searchRepr(value string) string {
+	var doc interface{}
+	err := json.Unmarshal([]byte(value), &doc)
+	if err == nil {
+		switch doc.(type) {
+		case map[string]interface{}, []interface{}:
+		default:
+			repr, _ := json.Marshal(doc)
+			return string(repr)
+		}
+	}
+
+	repr, _ := json.Marshal(value)
+	return string(repr)
+}
+
+// indexesFor returns the indexes declared for prefixes of key
+func indexesFor(stub compat.Stub, key string) ([]indexDecl, error) {
+	startKey := internalKey(indexKind)
+	iter, err := stub.RangeQueryState(startKey, startKey+lastKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed querying indexes: [%s]", err)
+	}
+	defer iter.Close()
+
+	indexes := []indexDecl{}
+	for iter.HasNext() {
+		_, declAsBytes, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed iterating indexes: [%s]", err)
+		}
+
+		var decl indexDecl
+		err = json.Unmarshal(declAsBytes, &decl)
+		if err != nil {
+			return nil, fmt.Errorf("Failed decoding index: [%s]", err)
+		}
+		if strings.HasPrefix(key, decl.Prefix) {
+			indexes = append(indexes, decl)
+		}
+	}
+	return indexes, nil
+}
+
+// updateIndexes replaces the index entries of key for its previous value by those for value.
+// A nil value removes them.
+func updateIndexes(stub compat.Stub, key string, previous, value []byte) error {
+	indexes, err := indexesFor(stub, key)
+	if err != nil {
+		return err
+	}
+
+	for _, decl := range indexes {
+		if previous != nil {
+			if repr, ok := fieldRepr(previous, decl.Path); ok {
+				err = stub.DelState(indexEntryKey(decl.Prefix, decl.Path, repr, key))
+				if err != nil {
+					return fmt.Errorf("Failed removing index entry of [%s]: [%s]", key, err)
+				}
+			}
+		}
+		if value != nil {
+			if repr, ok := fieldRepr(value, decl.Path); ok {
+				err = stub.PutState(indexEntryKey(decl.Prefix, decl.Path, repr, key), []byte(key))
+				if err != nil {
+					return fmt.Errorf("Failed storing index entry of [%s]: [%s]", key, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// declareIndex - invoke function declaring an index on a field of the JSON values of keys starting with prefix.
+// The keys already stored are indexed right away. Only the administrator can call it.
+func (t *SimpleChaincode) declareIndex(stub compat.Stub, args *router.Args) ([]byte, error) {
+	fmt.Println("running declareIndex()")
+
+	prefix, path := args.String("prefix"), args.String("path")
+	if strings.Contains(prefix, internalKeyPrefix) {
+		return nil, fmt.Errorf("Invalid prefix [%q]. Prefixes can't contain NUL characters", prefix)
+	}
+	if path == "" || strings.Contains(path, internalKeyPrefix) {
+		return nil, fmt.Errorf("Invalid field path [%q]", path)
+	}
+
+	err := checkAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	declAsBytes, err := stub.GetState(indexDeclKey(prefix, path))
+	if err != nil {
+		return nil, errors.New("Failed fetching indexes")
+	}
+	if declAsBytes != nil {
+		return nil, fmt.Errorf("Index on [%s] for prefix [%s] already declared", path, prefix)
+	}
+
+	declAsBytes, _ = json.Marshal(indexDecl{Prefix: prefix, Path: path})
+	err = stub.PutState(indexDeclKey(prefix, path), declAsBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed storing index: [%s]", err)
+	}
+
+	startKey := prefix
+	if startKey < firstKey {
+		startKey = firstKey
+	}
+	iter, err := stub.RangeQueryState(startKey, prefix+lastKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed querying keys: [%s]", err)
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed iterating keys: [%s]", err)
+		}
+
+		// Expired keys read as absent, they are indexed again only if rewritten
+		expired, err := isKeyExpired(stub, key)
+		if err != nil {
+			return nil, err
+		}
+		if expired {
+			continue
+		}
+
+		if repr, ok := fieldRepr(value, path); ok {
+			err = stub.PutState(indexEntryKey(prefix, path, repr, key), []byte(key))
+			if err != nil {
+				return nil, fmt.Errorf("Failed storing index entry of [%s]: [%s]", key, err)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// find - query function listing the keys starting with prefix whose field at path holds value
+func (t *SimpleChaincode) find(stub compat.Stub, args *router.Args) ([]byte, error) {
+	prefix, path := args.String("prefix"), args.String("path")
+
+	declAsBytes, err := stub.GetState(indexDeclKey(prefix, path))
+	if err != nil {
+		return nil, errors.New("Failed fetching indexes")
+	}
+	if declAsBytes == nil {
+		return nil, fmt.Errorf("No index on [%s] for prefix [%s]", path, prefix)
+	}
+
+	limit, err := pageLimit(args)
+	if err != nil {
+		return nil, err
+	}
+
+	entryPrefix := indexEntryKey(prefix, path, searchRepr(args.String("value")), "")
+	startKey, endKey := entryPrefix+firstKey, entryPrefix+lastKey
+	if args.Has("token") {
+		next, err := base64.URLEncoding.DecodeString(args.String("token"))
+		if err != nil {
+			return nil, errors.New("Invalid continuation token")
+		}
+		if entryPrefix+string(next) > startKey {
+			startKey = entryPrefix + string(next)
+		}
+	}
+
+	iter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed querying index: [%s]", err)
+	}
+	defer iter.Close()
+
+	result := &keyPage{Results: []keyValue{}}
+	for iter.HasNext() {
+		_, key, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Failed iterating index: [%s]", err)
+		}
+		expired, err := isKeyExpired(stub, string(key))
+		if err != nil {
+			return nil, err
+		}
+		if expired {
+			continue
+		}
+
+		if len(result.Results) == limit {
+			// Resume right after the last returned key
+			last := result.Results[limit-1].Key
+			result.Next = base64.URLEncoding.EncodeToString([]byte(last + "\x00"))
+			break
+		}
+
+		entry := keyValue{Key: string(key)}
+		if args.Bool("values") {
+			value, err := stub.GetState(entry.Key)
+			if err != nil {
+				return nil, fmt.Errorf("Failed getting state for [%s]: [%s]", entry.Key, err)
+			}
+			v := string(value)
+			entry.Value = &v
+		}
+		result.Results = append(result.Results, entry)
+	}
+
+	return json.Marshal(result)
+}
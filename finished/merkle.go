@@ -0,0 +1,203 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// The digest of the state is the root of a Merkle tree over the live keys in key order.
+//
+//	leaf     = SHA-256(0x00 || len(key) || key || len(value) || value)   lengths as 4-byte big endian
+//	internal = SHA-256(0x01 || left || right)
+//
+// A level with an odd number of nodes promotes its last node unchanged to the next level.
+// The root of an empty state is SHA-256 of nothing.
+
+// Hash domain separators of Merkle tree nodes
+const (
+	leafPrefix     = 0x00
+	internalPrefix = 0x01
+)
+
+// stateDigest is the JSON document returned by the digest query. Hashes are hex encoded.
+type stateDigest struct {
+	Root      string `json:"root"`
+	LeafCount int    `json:"leafCount"`
+}
+
+// proofStep is a sibling on the path from a leaf to the root.
+// Position tells whether the sibling is on the left or on the right of the running hash.
+type proofStep struct {
+	Hash     string `json:"hash"`
+	Position string `json:"position"`
+}
+
+// inclusionProof is the JSON document returned by the proof query. Hashes are hex encoded.
+type inclusionProof struct {
+	Key       string      `json:"key"`
+	Leaf      string      `json:"leaf"`
+	LeafIndex int         `json:"leafIndex"`
+	LeafCount int         `json:"leafCount"`
+	Path      []proofStep `json:"path"`
+	Root      string      `json:"root"`
+}
+
+// leafHash hashes a key/value pair
+func leafHash(key string, value []byte) []byte {
+	buf := make([]byte, 0, 9+len(key)+len(value))
+	buf = append(buf, leafPrefix)
+	buf = appendLength(buf, len(key))
+	buf = append(buf, key...)
+	buf = appendLength(buf, len(value))
+	buf = append(buf, value...)
+
+	hash := sha256.Sum256(buf)
+	return hash[:]
+}
+
+func appendLength(buf []byte, n int) []byte {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(n))
+	return append(buf, length[:]...)
+}
+
+// internalHash hashes two sibling nodes
+func internalHash(left, right []byte) []byte {
+	buf := make([]byte, 0, 1+len(left)+len(right))
+	buf = append(buf, internalPrefix)
+	buf = append(buf, left...)
+	buf = append(buf, right...)
+
+	hash := sha256.Sum256(buf)
+	return hash[:]
+}
+
+// stateLeaves returns the live keys in sorted order together with their leaf hashes
+func stateLeaves(stub compat.Stub) ([]string, [][]byte, error) {
+	iter, err := stub.RangeQueryState(firstKey, lastKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed querying keys: [%s]", err)
+	}
+	defer iter.Close()
+
+	keys := []string{}
+	leaves := [][]byte{}
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed iterating keys: [%s]", err)
+		}
+		expired, err := isKeyExpired(stub, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if expired {
+			continue
+		}
+
+		keys = append(keys, key)
+		leaves = append(leaves, leafHash(key, value))
+	}
+
+	return keys, leaves, nil
+}
+
+// merkleRoot returns the root of the tree over leaves and, if index is a leaf, the path from it to the root
+func merkleRoot(leaves [][]byte, index int) ([]byte, []proofStep) {
+	if len(leaves) == 0 {
+		hash := sha256.Sum256(nil)
+		return hash[:], nil
+	}
+
+	path := []proofStep{}
+	level := leaves
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+
+			switch index {
+			case i:
+				path = append(path, proofStep{Hash: hex.EncodeToString(level[i+1]), Position: "right"})
+			case i + 1:
+				path = append(path, proofStep{Hash: hex.EncodeToString(level[i]), Position: "left"})
+			}
+			next = append(next, internalHash(level[i], level[i+1]))
+		}
+
+		index /= 2
+		level = next
+	}
+	return level[0], path
+}
+
+// digest - query function returning the Merkle root over all the live keys and values
+func (t *SimpleChaincode) digest(stub compat.Stub, args *router.Args) ([]byte, error) {
+	_, leaves, err := stateLeaves(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	root, _ := merkleRoot(leaves, -1)
+	return json.Marshal(stateDigest{Root: hex.EncodeToString(root), LeafCount: len(leaves)})
+}
+
+// proof - query function returning the path proving that the current value of key is part of the digest
+func (t *SimpleChaincode) proof(stub compat.Stub, args *router.Args) ([]byte, error) {
+	key := args.String("key")
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, leaves, err := stateLeaves(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	index := -1
+	for i := range keys {
+		if keys[i] == key {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("Key [%s] not found", key)
+	}
+
+	root, path := merkleRoot(leaves, index)
+	return json.Marshal(inclusionProof{
+		Key:       key,
+		Leaf:      hex.EncodeToString(leaves[index]),
+		LeafIndex: index,
+		LeafCount: len(leaves),
+		Path:      path,
+		Root:      hex.EncodeToString(root),
+	})
+}
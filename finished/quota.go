@@ -0,0 +1,238 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// defaultQuotaKey holds the quota of the callers without a quota of their own
+var defaultQuotaKey = internalKey(configKind, "quota")
+
+// quota limits what a caller can store. Zero means unlimited.
+type quota struct {
+	MaxValueSize int `json:"maxValueSize"` // bytes of a single value
+	MaxKeyLength int `json:"maxKeyLength"` // bytes of a key
+	MaxKeys      int `json:"maxKeys"`      // keys owned at once
+}
+
+// usage is what a caller owns, identified by the fingerprint of its certificate
+type usage struct {
+	Keys  int   `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+// usageReport is the JSON document returned by the usage query
+type usageReport struct {
+	Caller string `json:"caller"`
+	Usage  usage  `json:"usage"`
+	Quota  quota  `json:"quota"`
+}
+
+// quotaKey is the state key of the quota of the caller with the given fingerprint
+func quotaKey(caller string) string {
+	return internalKey(quotaKind, caller)
+}
+
+// usageKey is the state key of the usage of the caller with the given fingerprint
+func usageKey(caller string) string {
+	return internalKey(usageKind, caller)
+}
+
+// getQuota returns the quota of the caller with the given fingerprint, falling back to the default quota
+func getQuota(stub compat.Stub, caller string) (*quota, error) {
+	q := &quota{}
+	for _, key := range []string{quotaKey(caller), defaultQuotaKey} {
+		quotaAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return nil, errors.New("Failed fetching quota")
+		}
+		if len(quotaAsBytes) == 0 {
+			continue
+		}
+
+		err = json.Unmarshal(quotaAsBytes, q)
+		if err != nil {
+			return nil, fmt.Errorf("Failed decoding quota: [%s]", err)
+		}
+		return q, nil
+	}
+	return q, nil
+}
+
+// getUsage returns the usage of the caller with the given fingerprint
+func getUsage(stub compat.Stub, caller string) (*usage, error) {
+	u := &usage{}
+
+	usageAsBytes, err := stub.GetState(usageKey(caller))
+	if err != nil {
+		return nil, errors.New("Failed fetching usage")
+	}
+	if len(usageAsBytes) == 0 {
+		return u, nil
+	}
+
+	err = json.Unmarshal(usageAsBytes, u)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decoding usage: [%s]", err)
+	}
+	return u, nil
+}
+
+// trackUsage adds keys and bytes to the usage of the owner certificate
+func trackUsage(stub compat.Stub, owner []byte, keys int, bytes int64) error {
+	if keys == 0 && bytes == 0 {
+		return nil
+	}
+
+	caller := fingerprint(owner)
+	u, err := getUsage(stub, caller)
+	if err != nil {
+		return err
+	}
+
+	u.Keys += keys
+	u.Bytes += bytes
+	if u.Keys < 0 {
+		// Keys created before quotas were introduced were never counted
+		u.Keys = 0
+	}
+	if u.Bytes < 0 {
+		u.Bytes = 0
+	}
+
+	usageAsBytes, _ := json.Marshal(u)
+	err = stub.PutState(usageKey(caller), usageAsBytes)
+	if err != nil {
+		return fmt.Errorf("Failed storing usage: [%s]", err)
+	}
+	return nil
+}
+
+// checkQuota verifies that key and value fit the caller's quota
+func checkQuota(stub compat.Stub, key string, value []byte) error {
+	caller, err := callerFingerprint(stub)
+	if err != nil {
+		return err
+	}
+	return checkSizeQuota(stub, caller, key, value)
+}
+
+// checkSizeQuota verifies that key and value fit the quota of the caller with the given fingerprint
+func checkSizeQuota(stub compat.Stub, caller string, key string, value []byte) error {
+	q, err := getQuota(stub, caller)
+	if err != nil {
+		return err
+	}
+	if q.MaxKeyLength > 0 && len(key) > q.MaxKeyLength {
+		return fmt.Errorf("Quota exceeded. Key [%s] is longer than %d bytes", key, q.MaxKeyLength)
+	}
+	if q.MaxValueSize > 0 && len(value) > q.MaxValueSize {
+		return fmt.Errorf("Quota exceeded. The value of [%s] is larger than %d bytes", key, q.MaxValueSize)
+	}
+	return nil
+}
+
+// checkKeyQuota verifies that owner can own one more key
+func checkKeyQuota(stub compat.Stub, owner []byte) error {
+	caller := fingerprint(owner)
+	q, err := getQuota(stub, caller)
+	if err != nil {
+		return err
+	}
+	if q.MaxKeys == 0 {
+		return nil
+	}
+
+	u, err := getUsage(stub, caller)
+	if err != nil {
+		return err
+	}
+	if u.Keys >= q.MaxKeys {
+		return fmt.Errorf("Quota exceeded. The caller already owns %d keys", u.Keys)
+	}
+	return nil
+}
+
+// setQuota - invoke function setting the default quota, or the quota of the caller with the given fingerprint.
+// Only the administrator can set quotas. A null quota makes the caller fall back to the default one.
+func (t *SimpleChaincode) setQuota(stub compat.Stub, args *router.Args) ([]byte, error) {
+	err := checkAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	key := defaultQuotaKey
+	if args.Has("caller") {
+		key = quotaKey(args.String("caller"))
+	}
+
+	var q *quota
+	err = json.Unmarshal([]byte(args.String("quota")), &q)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid quota document: [%s]", err)
+	}
+
+	if q == nil {
+		err = stub.DelState(key)
+		if err != nil {
+			return nil, fmt.Errorf("Failed removing quota: [%s]", err)
+		}
+		return nil, nil
+	}
+
+	if q.MaxValueSize < 0 || q.MaxKeyLength < 0 || q.MaxKeys < 0 {
+		return nil, errors.New("Invalid quota. Limits can't be negative")
+	}
+
+	quotaAsBytes, _ := json.Marshal(q)
+	err = stub.PutState(key, quotaAsBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed storing quota: [%s]", err)
+	}
+	return nil, nil
+}
+
+// callerUsage - query function returning the usage and quota of the caller with the given fingerprint,
+// by default the caller of the query
+func (t *SimpleChaincode) callerUsage(stub compat.Stub, args *router.Args) ([]byte, error) {
+	caller := args.String("caller")
+	if !args.Has("caller") {
+		var err error
+		caller, err = callerFingerprint(stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	u, err := getUsage(stub, caller)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := getQuota(stub, caller)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(usageReport{Caller: caller, Usage: *u, Quota: *q})
+}
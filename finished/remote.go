@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// remotesKey holds the JSON array of the names of the chaincodes read_remote and write_from_remote may query.
+// It is set at deploy time; without it no chaincode can be queried.
+var remotesKey = internalKey(configKind, "remotes")
+
+// setRemotes stores the allowlist of chaincode names passed to Init as a JSON array
+func setRemotes(stub compat.Stub, remotes string) error {
+	var names []string
+	err := json.Unmarshal([]byte(remotes), &names)
+	if err != nil {
+		return fmt.Errorf("Invalid chaincode allowlist. Expecting a JSON array of names: [%s]", err)
+	}
+	for _, name := range names {
+		if name == "" {
+			return errors.New("Invalid chaincode allowlist. Empty name.")
+		}
+	}
+
+	namesAsBytes, _ := json.Marshal(names)
+	err = stub.PutState(remotesKey, namesAsBytes)
+	if err != nil {
+		return fmt.Errorf("Failed storing chaincode allowlist: [%s]", err)
+	}
+	return nil
+}
+
+// queryRemote queries function of chaincode with args, if the chaincode is allowlisted
+func queryRemote(stub compat.Stub, chaincode, function string, args []string) ([]byte, error) {
+	namesAsBytes, err := stub.GetState(remotesKey)
+	if err != nil {
+		return nil, errors.New("Failed fetching chaincode allowlist")
+	}
+
+	var names []string
+	if len(namesAsBytes) != 0 {
+		err = json.Unmarshal(namesAsBytes, &names)
+		if err != nil {
+			return nil, fmt.Errorf("Failed decoding chaincode allowlist: [%s]", err)
+		}
+	}
+
+	allowed := false
+	for _, name := range names {
+		if name == chaincode {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("Chaincode [%s] is not allowed", chaincode)
+	}
+
+	input := make([][]byte, 0, len(args)+1)
+	input = append(input, []byte(function))
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+
+	result, err := stub.QueryChaincode(chaincode, input)
+	if err != nil {
+		return nil, fmt.Errorf("Failed querying %s on chaincode [%s]: [%s]", function, chaincode, err)
+	}
+	return result, nil
+}
+
+// readRemote - query function returning the result of a query of another chaincode
+func (t *SimpleChaincode) readRemote(stub compat.Stub, args *router.Args) ([]byte, error) {
+	return queryRemote(stub, args.String("chaincode"), args.String("function"), args.Strings("args"))
+}
+
+// writeFromRemote - invoke function writing under key the result of a query of another chaincode
+func (t *SimpleChaincode) writeFromRemote(stub compat.Stub, args *router.Args) ([]byte, error) {
+	fmt.Println("running writeFromRemote()")
+
+	key := args.String("key")
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := queryRemote(stub, args.String("chaincode"), args.String("function"), args.Strings("args"))
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("Chaincode [%s] returned no value for [%s]", args.String("chaincode"), key)
+	}
+
+	return writeKey(stub, key, value, 0)
+}
@@ -0,0 +1,280 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
+)
+
+// jsonSchema is the subset of JSON Schema supported by register_schema:
+// type, properties, required, items, enum, minimum/maximum and minLength/maxLength.
+// Schemas using any other keyword are rejected.
+type jsonSchema struct {
+	Type       string                 `json:"type,omitempty"`
+	Properties map[string]*jsonSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+	Items      *jsonSchema            `json:"items,omitempty"`
+	Enum       []interface{}          `json:"enum,omitempty"`
+	Minimum    *float64               `json:"minimum,omitempty"`
+	Maximum    *float64               `json:"maximum,omitempty"`
+	MinLength  *int                   `json:"minLength,omitempty"`
+	MaxLength  *int                   `json:"maxLength,omitempty"`
+}
+
+// schemaError is the JSON error returned when a value doesn't validate under the schema of its key
+type schemaError struct {
+	Error  string   `json:"Error"`
+	Key    string   `json:"key"`
+	Prefix string   `json:"prefix"`
+	Errors []string `json:"errors"`
+}
+
+// schemaTypes are the supported values of the type keyword
+var schemaTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true, "integer": true, "boolean": true, "null": true,
+}
+
+// check verifies that the schema only uses supported keywords and types
+func (s *jsonSchema) check(path string) error {
+	if s.Type != "" && !schemaTypes[s.Type] {
+		return fmt.Errorf("Unsupported type [%s] at %s", s.Type, path)
+	}
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("Invalid schema for property [%s] at %s", name, path)
+		}
+		err := property.check(path + "." + name)
+		if err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check(path + "[]")
+	}
+	return nil
+}
+
+// decodeSchema decodes a schema document, rejecting the keywords jsonSchema doesn't support
+// instead of silently ignoring them
+func decodeSchema(document string) (*jsonSchema, error) {
+	decoder := json.NewDecoder(strings.NewReader(document))
+	decoder.DisallowUnknownFields()
+
+	schema := &jsonSchema{}
+	err := decoder.Decode(schema)
+	if err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// validate returns the reasons why value, decoded from JSON, doesn't match the schema
+func (s *jsonSchema) validate(value interface{}, path string) []string {
+	var errs []string
+
+	if s.Type != "" && !hasType(value, s.Type) {
+		return append(errs, fmt.Sprintf("%s: expected %s", path, s.Type))
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if reflect.DeepEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: not one of the allowed values", path))
+		}
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			errs = append(errs, fmt.Sprintf("%s: %v is less than the minimum %v", path, v, *s.Minimum))
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			errs = append(errs, fmt.Sprintf("%s: %v is greater than the maximum %v", path, v, *s.Maximum))
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			errs = append(errs, fmt.Sprintf("%s: shorter than %d characters", path, *s.MinLength))
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			errs = append(errs, fmt.Sprintf("%s: longer than %d characters", path, *s.MaxLength))
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required property [%s]", path, name))
+			}
+		}
+
+		// Sort the properties so errors are reported in a deterministic order
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := v[name]; ok {
+				errs = append(errs, s.Properties[name].validate(property, path+"."+name)...)
+			}
+		}
+	}
+
+	return errs
+}
+
+// hasType tells whether value, decoded from JSON, is of the JSON Schema type typ
+func hasType(value interface{}, typ string) bool {
+	switch v := value.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case float64:
+		return typ == "number" || (typ == "integer" && v == math.Trunc(v))
+	case string:
+		return typ == "string"
+	case []interface{}:
+		return typ == "array"
+	case map[string]interface{}:
+		return typ == "object"
+	}
+	return false
+}
+
+// schemaKey is the state key of the schema registered for prefix
+func schemaKey(prefix string) string {
+	return internalKey(schemaKind, prefix)
+}
+
+// schemaFor returns the schema registered for the longest prefix of key, or nil if there is none
+func schemaFor(stub compat.Stub, key string) (*jsonSchema, string, error) {
+	startKey := internalKey(schemaKind)
+	iter, err := stub.RangeQueryState(startKey, startKey+lastKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed querying schemas: [%s]", err)
+	}
+	defer iter.Close()
+
+	var schemaAsBytes []byte
+	prefix := ""
+	found := false
+	for iter.HasNext() {
+		indexKey, value, err := iter.Next()
+		if err != nil {
+			return nil, "", fmt.Errorf("Failed iterating schemas: [%s]", err)
+		}
+
+		candidate := strings.TrimPrefix(indexKey, startKey)
+		if strings.HasPrefix(key, candidate) && (!found || len(candidate) > len(prefix)) {
+			schemaAsBytes, prefix, found = value, candidate, true
+		}
+	}
+	if !found {
+		return nil, "", nil
+	}
+
+	schema := &jsonSchema{}
+	err = json.Unmarshal(schemaAsBytes, schema)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed decoding schema for prefix [%s]: [%s]", prefix, err)
+	}
+	return schema, prefix, nil
+}
+
+// checkSchema verifies that value validates under the schema registered for key, if any
+func checkSchema(stub compat.Stub, key string, value []byte) error {
+	schema, prefix, err := schemaFor(stub, key)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return nil
+	}
+
+	var errs []string
+	var doc interface{}
+	err = json.Unmarshal(value, &doc)
+	if err != nil {
+		errs = []string{fmt.Sprintf("value is not valid JSON: %s", err)}
+	} else {
+		errs = schema.validate(doc, "$")
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	jsonResp, _ := json.Marshal(schemaError{Error: "schema validation failed", Key: key, Prefix: prefix, Errors: errs})
+	return errors.New(string(jsonResp))
+}
+
+// registerSchema - invoke function to require the values of keys starting with prefix to
+// validate under a JSON schema. Only the administrator can call it.
+func (t *SimpleChaincode) registerSchema(stub compat.Stub, args *router.Args) ([]byte, error) {
+	fmt.Println("running registerSchema()")
+
+	prefix := args.String("prefix")
+	if strings.Contains(prefix, internalKeyPrefix) {
+		return nil, fmt.Errorf("Invalid prefix [%q]. Prefixes can't contain NUL characters", prefix)
+	}
+
+	err := checkAdmin(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := decodeSchema(args.String("schema"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid schema: [%s]", err)
+	}
+	err = schema.check("$")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid schema: [%s]", err)
+	}
+
+	schemaAsBytes, err := json.Marshal(schema)
+	if err != nil {
+		return nil, fmt.Errorf("Failed encoding schema: [%s]", err)
+	}
+
+	err = stub.PutState(schemaKey(prefix), schemaAsBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed storing schema for prefix [%s]: [%s]", prefix, err)
+	}
+	return nil, nil
+}
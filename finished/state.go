@@ -0,0 +1,362 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/shiliy/learn-chaincode/compat"
+)
+
+// Keys starting with internalKeyPrefix hold the chaincode's own bookkeeping.
+// They sort before every user key and can't be read or written through the
+// key/value functions. The prefix also separates the parts of internal keys,
+// so user keys can't contain it at all.
+const internalKeyPrefix = "\x00"
+
+// firstKey is the smallest key a user can write
+const firstKey = "\x01"
+
+// Kinds of internal records
+const (
+	metaKind       = "meta"
+	historyKind    = "history"
+	expiryKind     = "expiry"
+	configKind     = "config"
+	schemaKind     = "schema"
+	quotaKind      = "quota"
+	usageKind      = "usage"
+	indexKind      = "index" // index declarations
+	indexEntryKind = "idx"   // index entries
+)
+
+// keyMeta is the bookkeeping kept next to every key written through the chaincode
+type keyMeta struct {
+	Version   uint64     `json:"version"`
+	Tombstone *tombstone `json:"tombstone,omitempty"`
+	Expiry    int64      `json:"expiry,omitempty"` // Unix seconds after which the key reads as absent, 0 for never
+	Owner     []byte     `json:"owner,omitempty"`  // certificate of the creator of the key
+}
+
+// tombstone records who deleted a key, so that reading it can tell it apart
+// from a key that was never written
+type tombstone struct {
+	DeletedBy string `json:"deletedBy"` // hex SHA-256 fingerprint of the deleter certificate
+	TxID      string `json:"txID"`
+}
+
+// deletedError is the JSON error returned when reading a key deleted with a tombstone
+type deletedError struct {
+	Error     string `json:"Error"`
+	Key       string `json:"key"`
+	DeletedBy string `json:"deletedBy"`
+	TxID      string `json:"txID"`
+}
+
+// internalKey builds the state key of an internal record of the given kind
+func internalKey(kind string, parts ...string) string {
+	return internalKeyPrefix + kind + internalKeyPrefix + strings.Join(parts, internalKeyPrefix)
+}
+
+// validateKey rejects keys that can't be used through the key/value functions
+func validateKey(key string) error {
+	if key == "" {
+		return errors.New("Invalid key. Empty.")
+	}
+	if strings.Contains(key, internalKeyPrefix) {
+		return fmt.Errorf("Invalid key [%q]. Keys can't contain NUL characters", key)
+	}
+	if !utf8.ValidString(key) {
+		// Range queries stop at lastKey, which only sorts after valid UTF-8
+		return fmt.Errorf("Invalid key [%q]. Keys must be valid UTF-8", key)
+	}
+	return nil
+}
+
+// getMeta returns the bookkeeping of key, or a zero keyMeta if the key was never written
+func getMeta(stub compat.Stub, key string) (*keyMeta, error) {
+	meta := &keyMeta{}
+
+	metaAsBytes, err := stub.GetState(internalKey(metaKind, key))
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting metadata for [%s]: [%s]", key, err)
+	}
+	if len(metaAsBytes) == 0 {
+		return meta, nil
+	}
+
+	err = json.Unmarshal(metaAsBytes, meta)
+	if err != nil {
+		return nil, fmt.Errorf("Failed decoding metadata for [%s]: [%s]", key, err)
+	}
+	return meta, nil
+}
+
+// putMeta stores the bookkeeping of key
+func putMeta(stub compat.Stub, key string, meta *keyMeta) error {
+	metaAsBytes, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("Failed encoding metadata for [%s]: [%s]", key, err)
+	}
+
+	err = stub.PutState(internalKey(metaKind, key), metaAsBytes)
+	if err != nil {
+		return fmt.Errorf("Failed storing metadata for [%s]: [%s]", key, err)
+	}
+	return nil
+}
+
+// getValue returns the value of key together with its bookkeeping.
+// Expired keys read as absent, keys deleted with a tombstone return the JSON "deleted" error.
+func getValue(stub compat.Stub, key string) ([]byte, *keyMeta, error) {
+	valAsbytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, nil, errors.New("{\"Error\":\"Failed to get state for " + key + "\"}")
+	}
+
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if valAsbytes == nil && meta.Tombstone != nil {
+		jsonResp, _ := json.Marshal(deletedError{Error: "deleted", Key: key, DeletedBy: meta.Tombstone.DeletedBy, TxID: meta.Tombstone.TxID})
+		return nil, nil, errors.New(string(jsonResp))
+	}
+
+	expired, err := isExpired(stub, meta)
+	if err != nil {
+		return nil, nil, err
+	}
+	if expired {
+		return nil, meta, nil
+	}
+
+	return valAsbytes, meta, nil
+}
+
+// setValue stores value under key, bumps the key's version and records the change in its history.
+// It returns the change to report in the transaction event. A non-zero expiry (Unix seconds) makes the key read as absent from then on.
+// A key that expired but was not purged yet is purged first, so that the caller creates it afresh.
+func setValue(stub compat.Stub, key string, value []byte, expiry int64) (keyChange, error) {
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	previous, err := stub.GetState(key)
+	if err != nil {
+		return keyChange{}, fmt.Errorf("Failed getting state for [%s]: [%s]", key, err)
+	}
+
+	expired, err := isExpired(stub, meta)
+	if err != nil {
+		return keyChange{}, err
+	}
+	if previous != nil && expired {
+		_, err = removeValue(stub, key, false)
+		if err != nil {
+			return keyChange{}, err
+		}
+
+		meta, err = getMeta(stub, key)
+		if err != nil {
+			return keyChange{}, err
+		}
+		previous = nil
+	}
+
+	created := 0
+	if previous == nil {
+		// The caller creates the key and becomes its owner
+		meta.Owner, err = stub.GetCallerCertificate()
+		if err != nil {
+			return keyChange{}, fmt.Errorf("Failed getting caller certificate: [%s]", err)
+		}
+
+		err = checkKeyQuota(stub, meta.Owner)
+		if err != nil {
+			return keyChange{}, err
+		}
+		created = 1
+	}
+
+	meta.Version++
+	meta.Tombstone = nil
+
+	err = stub.PutState(key, value)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = updateIndexes(stub, key, previous, value)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = setExpiry(stub, key, meta, expiry)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = trackUsage(stub, meta.Owner, created, int64(len(value)-len(previous)))
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = recordHistory(stub, key, meta.Version, previous, value, false)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = putMeta(stub, key, meta)
+	if err != nil {
+		return keyChange{}, err
+	}
+	return writeChange(key, value, meta.Version), nil
+}
+
+// removeValue deletes key, bumps its version and records the deletion in its history.
+// With keepTombstone, later reads of key report who deleted it.
+func removeValue(stub compat.Stub, key string, keepTombstone bool) (keyChange, error) {
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	previous, err := stub.GetState(key)
+	if err != nil {
+		return keyChange{}, fmt.Errorf("Failed getting state for [%s]: [%s]", key, err)
+	}
+
+	if previous != nil {
+		err = trackUsage(stub, meta.Owner, -1, -int64(len(previous)))
+		if err != nil {
+			return keyChange{}, err
+		}
+	}
+
+	meta.Version++
+	meta.Tombstone = nil
+	meta.Owner = nil
+	if keepTombstone {
+		deleter, err := callerFingerprint(stub)
+		if err != nil {
+			return keyChange{}, err
+		}
+		meta.Tombstone = &tombstone{DeletedBy: deleter, TxID: stub.GetTxID()}
+	}
+
+	err = stub.DelState(key)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = updateIndexes(stub, key, previous, nil)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = setExpiry(stub, key, meta, 0)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = recordHistory(stub, key, meta.Version, previous, nil, true)
+	if err != nil {
+		return keyChange{}, err
+	}
+
+	err = putMeta(stub, key, meta)
+	if err != nil {
+		return keyChange{}, err
+	}
+	return deleteChange(key, meta.Version), nil
+}
+
+// checkWrite verifies that the caller can write value under key
+func checkWrite(stub compat.Stub, key string, value []byte) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	valAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return fmt.Errorf("Failed getting state for [%s]: [%s]", key, err)
+	}
+	if valAsBytes != nil {
+		// Anyone can create a key, or recreate an expired one, only its owner can overwrite it
+		meta, err := getMeta(stub, key)
+		if err != nil {
+			return err
+		}
+
+		expired, err := isExpired(stub, meta)
+		if err != nil {
+			return err
+		}
+		if !expired {
+			err = checkOwner(stub, key, meta)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = checkQuota(stub, key, value)
+	if err != nil {
+		return err
+	}
+
+	return checkSchema(stub, key, value)
+}
+
+// checkDelete verifies that key exists and the caller can delete it
+func checkDelete(stub compat.Stub, key string) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	valAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return fmt.Errorf("Failed getting state for [%s]: [%s]", key, err)
+	}
+	if valAsBytes == nil {
+		return fmt.Errorf("Key [%s] not found", key)
+	}
+
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return err
+	}
+
+	expired, err := isExpired(stub, meta)
+	if err != nil {
+		return err
+	}
+	if expired {
+		// Expired keys are left to purge_expired
+		return fmt.Errorf("Key [%s] not found", key)
+	}
+	return checkOwner(stub, key, meta)
+}
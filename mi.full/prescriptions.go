@@ -3,7 +3,10 @@ package main
 import (
 	"errors"
 	"fmt"
+	"sync"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shiliy/learn-chaincode/compat"
+	"github.com/shiliy/learn-chaincode/router"
 	"encoding/json"
 )
 
@@ -48,6 +51,8 @@ const	AUTHORIZATION_STATE_CANCELED = 3
 //				and other HyperLedger functions)
 //==============================================================================================================================
 type  SimpleChaincode struct {
+	once		sync.Once
+	routes	*router.Router
 }
 
 //==============================================================================================================================
@@ -108,7 +113,7 @@ type Authorization_Registry struct {
 //==============================================================================================================================
 //	Init Function - Called when the user deploys the chaincode
 //==============================================================================================================================
-func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Init(stub compat.Stub, function string, args []string) ([]byte, error) {
 
 	//Args
 	//				0
@@ -144,7 +149,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 // save_changes - Writes to the ledger the Vehicle struct passed in a JSON format. Uses the shim file's
 //				  method 'PutState'.
 //==============================================================================================================================
-func (entry Base) save_changes(stub shim.ChaincodeStubInterface) (error) {
+func (entry Base) save_changes(stub compat.Stub) (error) {
 
 	bytes, err := json.Marshal(entry)
 
@@ -162,7 +167,7 @@ func (entry Base) save_changes(stub shim.ChaincodeStubInterface) (error) {
 // save_changes - Writes to the ledger the Vehicle struct passed in a JSON format. Uses the shim file's
 //				  method 'PutState'.
 //==============================================================================================================================
-func (t *SimpleChaincode) save_changes(stub shim.ChaincodeStubInterface, entry interface{}, id string) (bool, error) {
+func (t *SimpleChaincode) save_changes(stub compat.Stub, entry interface{}, id string) (bool, error) {
 
 	bytes, err := json.Marshal(entry)
 
@@ -178,51 +183,74 @@ func (t *SimpleChaincode) save_changes(stub shim.ChaincodeStubInterface, entry i
 
 //==============================================================================================================================
 //	 Router Functions
+//==============================================================================================================================
+//	functions - The registry of the functions callable through Invoke and Query, with the arguments each of them expects
+//==============================================================================================================================
+func (t *SimpleChaincode) functions() *router.Router {
+	t.once.Do(func() {
+		t.routes = router.New().
+			Invoke(router.Function{
+				Name:        "create_patient",
+				Description: "Creates a patient with the given insurer and doctor",
+				Args:        []router.Arg{{Name: "patientID"}, {Name: "insurerID"}, {Name: "doctorID"}},
+				Handler:     t.create_patient,
+			}).
+			Invoke(router.Function{
+				Name:        "create_prescription",
+				Description: "Creates a prescription of a medication for a patient",
+				Args:        []router.Arg{{Name: "prescriptionID"}, {Name: "patientID"}, {Name: "DIN"}},
+				Handler:     t.create_prescription,
+			}).
+			Invoke(router.Function{
+				Name:        "create_authorization",
+				Description: "Creates an authorization request for a prescription",
+				Args:        []router.Arg{{Name: "authorizationID"}, {Name: "prescriptionID"}},
+				Handler:     t.create_authorization,
+			}).
+			Invoke(router.Function{
+				Name:        "approve_authorization",
+				Description: "Approves a newly created authorization. Invocations of unregistered functions are approvals too, as before the registry",
+				Args:        []router.Arg{{Name: "authorizationID"}},
+				Handler:     t.approve,
+			}).
+			Query(router.Function{
+				Name:        "get_details",
+				Description: "Returns the patient, prescription or authorization stored under an ID",
+				Args:        []router.Arg{{Name: "ID"}},
+				Handler:     t.details,
+			})
+	})
+	return t.routes
+}
+
 //==============================================================================================================================
 //	Invoke - Called on chaincode invoke. Takes a function name passed and calls that function. Converts some
 //		  initial arguments passed to other things for use in the called function e.g. name -> ecert
 //==============================================================================================================================
-func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Invoke(stub compat.Stub, function string, args []string) ([]byte, error) {
 
-	if function == "create_authorization" {
-      return t.create_authorization(stub, args)
-	} else if function == "create_patient" {
-			return t.create_patient(stub, args)
-	} else if function == "create_prescription" {
-			return t.create_prescription(stub, args)
-	} else if function == "create_authorization" {
-			return t.create_authorization(stub, args)
-	} else {
-			// If the function is not a create then there must be a authorization so we need to retrieve it.
-			var a Authorization
-			bytes, err := stub.GetState(args[0])
-
-			if err != nil {	fmt.Printf("INVOKE: reqeust can't be found : %s", err); return nil, errors.New("INVOKE: reqeust can't be found ")	}
-
-			err = json.Unmarshal(bytes, &a);
-			if err != nil {	fmt.Printf("INVOKE: authorization corrupted : %s", err); return nil, errors.New("INVOKE: reqeust corrupted "+string(bytes))	}
-
-			return t.approve_authorization(stub, a)
-		}
+	// Clients written before the registry approve an authorization under any other function name
+	if !t.functions().IsInvoke(function) {
+		function = "approve_authorization"
+	}
 
-	return nil, errors.New("Function of the name "+ function +" doesn't exist.")
+	return t.functions().HandleInvoke(stub, function, args)
 
 }
 
-func (t *SimpleChaincode) create_patient(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func (t *SimpleChaincode) create_patient(stub compat.Stub, args *router.Args) ([]byte, error) {
 
 	var p Patient
-	if len(args) != 3 { fmt.Printf("create_patient: Incorrect number of arguments passed"); return nil, errors.New("create_patient: Incorrect number of arguments passed") }
-	record, err := stub.GetState(args[0]) 								// If not an error then a record exists so cant create a new authorization
+	record, err := stub.GetState(args.String("patientID")) 								// If not an error then a record exists so cant create a new authorization
 
   if record != nil { return nil, errors.New("patient already exists") }
 
 	// if 	caller_affiliation != AUTHORITY {							// Only the regulator can create a new v5c
 	//	return nil, errors.New(fmt.Sprintf("Permission Denied. create_authorization. %v === %v", caller_affiliation, AUTHORITY))
 	// }
-	p.ID = args[0]
-	p.InsurerID = args[1]
-	p.DoctorID = args[2]
+	p.ID = args.String("patientID")
+	p.InsurerID = args.String("insurerID")
+	p.DoctorID = args.String("doctorID")
 	_, err  = t.save_changes(stub, p, p.ID)
 
 	if err != nil { fmt.Printf("create_patient: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
@@ -231,12 +259,11 @@ func (t *SimpleChaincode) create_patient(stub shim.ChaincodeStubInterface, args
 }
 
 
-func (t *SimpleChaincode) create_prescription(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func (t *SimpleChaincode) create_prescription(stub compat.Stub, args *router.Args) ([]byte, error) {
 
 	var p Prescription
-	if len(args) != 3 { fmt.Printf("create_prescription: Incorrect number of arguments passed"); return nil, errors.New("create_patient: Incorrect number of arguments passed") }
 
-	record, err := stub.GetState(args[0]) 								// If not an error then a record exists so cant create a new authorization
+	record, err := stub.GetState(args.String("prescriptionID")) 								// If not an error then a record exists so cant create a new authorization
 
   if record != nil { return nil, errors.New("prescription already exists") }
 
@@ -244,9 +271,9 @@ func (t *SimpleChaincode) create_prescription(stub shim.ChaincodeStubInterface,
 	//	return nil, errors.New(fmt.Sprintf("Permission Denied. create_authorization. %v === %v", caller_affiliation, AUTHORITY))
 	// }
 
-	p.ID = args[0]
-	p.PatientID = args[1]
-	p.DIN = args[2]
+	p.ID = args.String("prescriptionID")
+	p.PatientID = args.String("patientID")
+	p.DIN = args.String("DIN")
 	p.State = MEDICATION_STATE_SUBMITTED
 
 	_, err  = t.save_changes(stub, p, p.ID)
@@ -256,12 +283,10 @@ func (t *SimpleChaincode) create_prescription(stub shim.ChaincodeStubInterface,
 	return []byte (p.ID), nil
 }
 
-func (t *SimpleChaincode) create_authorization(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func (t *SimpleChaincode) create_authorization(stub compat.Stub, args *router.Args) ([]byte, error) {
 
 	var a Authorization
 
-	if len(args) != 2 { fmt.Printf("create_authorization: Incorrect number of arguments passed"); return nil, errors.New("create_authorization: Incorrect number of arguments passed") }
-
 	record, err := stub.GetState(a.ID) 								// If not an error then a record exists so cant create a new authorization
 
   if record != nil { return nil, errors.New("authorization already exists") }
@@ -270,8 +295,8 @@ func (t *SimpleChaincode) create_authorization(stub shim.ChaincodeStubInterface,
 	//	return nil, errors.New(fmt.Sprintf("Permission Denied. create_authorization. %v === %v", caller_affiliation, AUTHORITY))
 	// }
 
-	a.ID = args[0]
-	a.PrescriptionID = args[1]
+	a.ID = args.String("authorizationID")
+	a.PrescriptionID = args.String("prescriptionID")
 
 	bytes, err := stub.GetState(a.PrescriptionID);
 
@@ -308,10 +333,26 @@ func (t *SimpleChaincode) create_authorization(stub shim.ChaincodeStubInterface,
 }
 //=================================================================================================================================
 //	 Transfer Functions
+//=================================================================================================================================
+//	 approve - Retrieves the authorization to approve
+//=================================================================================================================================
+func (t *SimpleChaincode) approve(stub compat.Stub, args *router.Args) ([]byte, error) {
+
+	var a Authorization
+	bytes, err := stub.GetState(args.String("authorizationID"))
+
+	if err != nil {	fmt.Printf("INVOKE: reqeust can't be found : %s", err); return nil, errors.New("INVOKE: reqeust can't be found ")	}
+
+	err = json.Unmarshal(bytes, &a);
+	if err != nil {	fmt.Printf("INVOKE: authorization corrupted : %s", err); return nil, errors.New("INVOKE: reqeust corrupted "+string(bytes))	}
+
+	return t.approve_authorization(stub, a)
+}
+
 //=================================================================================================================================
 //	 authority_to_manufacturer
 //=================================================================================================================================
-func (t *SimpleChaincode) approve_authorization(stub shim.ChaincodeStubInterface, a Authorization) ([]byte, error) {
+func (t *SimpleChaincode) approve_authorization(stub compat.Stub, a Authorization) ([]byte, error) {
 
 	if  a.State		== AUTHORIZATION_STATE_CREATED {		// If the roles and users are ok
 
@@ -337,23 +378,36 @@ func (t *SimpleChaincode) approve_authorization(stub shim.ChaincodeStubInterface
 //	Query - Called on chaincode query. Takes a function name passed and calls that function. Passes the
 //  		initial arguments passed are passed on to the called function.
 //=================================================================================================================================
-func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Query(stub compat.Stub, function string, args []string) ([]byte, error) {
 
+	return t.functions().HandleQuery(stub, function, args)
 
-	if function == "get_details" {
-		if len(args) != 1 { fmt.Printf("Incorrect number of arguments passed"); return nil, errors.New("QUERY: Incorrect number of arguments passed") }
-		return t.get_details(stub, args[0])
-	}
-	return nil, errors.New("Received unknown function invocation " + function)
+}
+
+//=================================================================================================================================
+//	IsQuery - Tells whether a function is a query, for Fabric versions where queries are called through Invoke
+//=================================================================================================================================
+func (t *SimpleChaincode) IsQuery(function string) bool {
+
+	return t.functions().IsQuery(function)
 
 }
 
 //=================================================================================================================================
 //	 Read Functions
+//=================================================================================================================================
+//	 details - Query handler of get_details
+//=================================================================================================================================
+func (t *SimpleChaincode) details(stub compat.Stub, args *router.Args) ([]byte, error) {
+
+	return t.get_details(stub, args.String("ID"))
+
+}
+
 //=================================================================================================================================
 //	 get_vehicle_details
 //=================================================================================================================================
-func (t *SimpleChaincode) get_details(stub shim.ChaincodeStubInterface, id string) ([]byte, error) {
+func (t *SimpleChaincode) get_details(stub compat.Stub, id string) ([]byte, error) {
 
 	bytes, err := stub.GetState(id);
 
@@ -369,7 +423,7 @@ func (t *SimpleChaincode) get_details(stub shim.ChaincodeStubInterface, id strin
 //=================================================================================================================================
 func main() {
 
-	err := shim.Start(new(SimpleChaincode))
+	err := compat.Start(new(SimpleChaincode))
 
 	if err != nil { fmt.Printf("Error starting Chaincode: %s", err) }
 }
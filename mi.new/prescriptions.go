@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/shiliy/learn-chaincode/compat"
 	"encoding/json"
 )
 
@@ -48,7 +49,7 @@ type Request struct {
 //==============================================================================================================================
 //	Init Function - Called when the user deploys the chaincode
 //==============================================================================================================================
-func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Init(stub compat.Stub, function string, args []string) ([]byte, error) {
 
 	//Args
 	//				0
@@ -74,7 +75,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 // save_changes - Writes to the ledger the Vehicle struct passed in a JSON format. Uses the shim file's
 //				  method 'PutState'.
 //==============================================================================================================================
-func (t *SimpleChaincode) save_changes(stub shim.ChaincodeStubInterface, r Request) (bool, error) {
+func (t *SimpleChaincode) save_changes(stub compat.Stub, r Request) (bool, error) {
 
 	bytes, err := json.Marshal(r)
 
@@ -94,7 +95,7 @@ func (t *SimpleChaincode) save_changes(stub shim.ChaincodeStubInterface, r Reque
 //	Invoke - Called on chaincode invoke. Takes a function name passed and calls that function. Converts some
 //		  initial arguments passed to other things for use in the called function e.g. name -> ecert
 //==============================================================================================================================
-func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Invoke(stub compat.Stub, function string, args []string) ([]byte, error) {
 
 	var r Request
 
@@ -116,7 +117,7 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function stri
 
 }
 
-func (t *SimpleChaincode) create_request(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+func (t *SimpleChaincode) create_request(stub compat.Stub, args []string) ([]byte, error) {
 	var r Request
 	r.ID = args[0]
 	r.DIN = args[1]
@@ -141,7 +142,7 @@ func (t *SimpleChaincode) create_request(stub shim.ChaincodeStubInterface, args
 //=================================================================================================================================
 //	 authority_to_manufacturer
 //=================================================================================================================================
-func (t *SimpleChaincode) review_request(stub shim.ChaincodeStubInterface, r Request) ([]byte, error) {
+func (t *SimpleChaincode) review_request(stub compat.Stub, r Request) ([]byte, error) {
 
 	if  r.State		== STATE_CREATED {		// If the roles and users are ok
 
@@ -167,23 +168,42 @@ func (t *SimpleChaincode) review_request(stub shim.ChaincodeStubInterface, r Req
 //	Query - Called on chaincode query. Takes a function name passed and calls that function. Passes the
 //  		initial arguments passed are passed on to the called function.
 //=================================================================================================================================
-func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Query(stub compat.Stub, function string, args []string) ([]byte, error) {
 
 
-	if function == "get_request_details" {
-		if len(args) != 1 { fmt.Printf("Incorrect number of arguments passed"); return nil, errors.New("QUERY: Incorrect number of arguments passed") }
-		return t.get_request_details(stub, args[0])
+	if query, ok := queries[function]; ok {
+		return query(t, stub, args)
 	}
 	return nil, errors.New("Received unknown function invocation " + function)
 
 }
 
+//=================================================================================================================================
+//	queries - The functions dispatched by Query, IsQuery reports exactly these
+//=================================================================================================================================
+var queries = map[string]func(t *SimpleChaincode, stub compat.Stub, args []string) ([]byte, error){
+	"get_request_details": func(t *SimpleChaincode, stub compat.Stub, args []string) ([]byte, error) {
+		if len(args) != 1 { fmt.Printf("Incorrect number of arguments passed"); return nil, errors.New("QUERY: Incorrect number of arguments passed") }
+		return t.get_request_details(stub, args[0])
+	},
+}
+
+//=================================================================================================================================
+//	IsQuery - Tells whether a function is a query, for Fabric versions where queries are called through Invoke
+//=================================================================================================================================
+func (t *SimpleChaincode) IsQuery(function string) bool {
+
+	_, ok := queries[function]
+	return ok
+
+}
+
 //=================================================================================================================================
 //	 Read Functions
 //=================================================================================================================================
 //	 get_vehicle_details
 //=================================================================================================================================
-func (t *SimpleChaincode) get_request_details(stub shim.ChaincodeStubInterface, id string) ([]byte, error) {
+func (t *SimpleChaincode) get_request_details(stub compat.Stub, id string) ([]byte, error) {
 
 	bytes, err := stub.GetState(id);
 
@@ -199,7 +219,7 @@ func (t *SimpleChaincode) get_request_details(stub shim.ChaincodeStubInterface,
 //=================================================================================================================================
 func main() {
 
-	err := shim.Start(new(SimpleChaincode))
+	err := compat.Start(new(SimpleChaincode))
 
 	if err != nil { fmt.Printf("Error starting Chaincode: %s", err) }
 }
@@ -0,0 +1,382 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package router dispatches chaincode invocations and queries to handlers registered
+// with the name and arguments of each function.
+//
+// The router checks the number and the types of the arguments before calling a handler,
+// reports malformed calls with uniform JSON errors, and answers the built-in "describe"
+// query with the signatures of all the registered functions:
+//
+//	r := router.New()
+//	r.Invoke(router.Function{
+//		Name:    "write",
+//		Args:    []router.Arg{{Name: "key"}, {Name: "value"}, {Name: "ttl", Type: router.Int, Optional: true}},
+//		Handler: t.write,
+//	})
+//
+//	func (t *SimpleChaincode) Invoke(stub compat.Stub, function string, args []string) ([]byte, error) {
+//		return r.HandleInvoke(stub, function, args)
+//	}
+package router
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shiliy/learn-chaincode/compat"
+)
+
+// DescribeFunction is the name of the built-in query listing the registered functions
+const DescribeFunction = "describe"
+
+// ArgType is the type of a function argument
+type ArgType int
+
+// Supported argument types
+const (
+	String ArgType = iota // any string
+	Int                   // decimal signed 64-bit integer
+	Uint                  // decimal unsigned 64-bit integer
+	Bool                  // true or false, as accepted by strconv.ParseBool
+	JSON                  // any valid JSON document
+	Base64                // standard base64 encoding of binary data, such as a certificate
+)
+
+var argTypeNames = map[ArgType]string{
+	String: "string",
+	Int:    "int",
+	Uint:   "uint",
+	Bool:   "bool",
+	JSON:   "json",
+	Base64: "base64",
+}
+
+// String returns the name of the type
+func (t ArgType) String() string {
+	if name, ok := argTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// MarshalJSON encodes the type by name
+func (t ArgType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// Arg describes one argument of a function.
+// Optional arguments must follow the required ones; passing an empty string omits them.
+// A variadic argument, only allowed last, collects zero or more remaining values.
+type Arg struct {
+	Name     string  `json:"name"`
+	Type     ArgType `json:"type"`
+	Optional bool    `json:"optional,omitempty"`
+	Variadic bool    `json:"variadic,omitempty"`
+}
+
+// Handler implements a function. args holds the arguments, already checked against the function spec.
+type Handler func(stub compat.Stub, args *Args) ([]byte, error)
+
+// Function is the spec of a callable function
+type Function struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Args        []Arg   `json:"args"`
+	Handler     Handler `json:"-"`
+}
+
+// Signature returns a human readable signature such as "write(key string, value string, [ttl int])"
+func (f *Function) Signature() string {
+	args := make([]string, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = arg.Name + " " + arg.Type.String()
+		if arg.Variadic {
+			args[i] = "[" + args[i] + "...]"
+		} else if arg.Optional {
+			args[i] = "[" + args[i] + "]"
+		}
+	}
+	return f.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// required returns the number of required arguments
+func (f *Function) required() int {
+	n := 0
+	for _, arg := range f.Args {
+		if !arg.Optional && !arg.Variadic {
+			n++
+		}
+	}
+	return n
+}
+
+// variadic tells whether the last argument collects the remaining values
+func (f *Function) variadic() bool {
+	return len(f.Args) > 0 && f.Args[len(f.Args)-1].Variadic
+}
+
+// Router holds the functions a chaincode exposes through Invoke and Query
+type Router struct {
+	invokes map[string]*Function
+	queries map[string]*Function
+}
+
+// New returns a router answering the built-in describe query
+func New() *Router {
+	r := &Router{
+		invokes: make(map[string]*Function),
+		queries: make(map[string]*Function),
+	}
+	r.Query(Function{
+		Name:        DescribeFunction,
+		Description: "Lists the callable functions and their signatures",
+		Handler:     r.describe,
+	})
+	return r
+}
+
+// Invoke registers a function callable through Invoke.
+// It panics on malformed specs, which are programming errors.
+func (r *Router) Invoke(f Function) *Router {
+	r.register(r.invokes, f)
+	return r
+}
+
+// Query registers a function callable through Query.
+// It panics on malformed specs, which are programming errors.
+func (r *Router) Query(f Function) *Router {
+	r.register(r.queries, f)
+	return r
+}
+
+func (r *Router) register(functions map[string]*Function, f Function) {
+	if f.Name == "" || f.Handler == nil {
+		panic("router: function without name or handler")
+	}
+	if _, ok := functions[f.Name]; ok {
+		panic("router: function " + f.Name + " registered twice")
+	}
+
+	names := make(map[string]bool, len(f.Args))
+	optional := false
+	for i, arg := range f.Args {
+		if arg.Name == "" || names[arg.Name] {
+			panic("router: function " + f.Name + " has a missing or duplicate argument name")
+		}
+		if _, ok := argTypeNames[arg.Type]; !ok {
+			panic("router: argument " + arg.Name + " of function " + f.Name + " has an unknown type")
+		}
+		if optional && !arg.Optional && !arg.Variadic {
+			panic("router: required argument " + arg.Name + " of function " + f.Name + " follows an optional one")
+		}
+		if arg.Variadic && i != len(f.Args)-1 {
+			panic("router: variadic argument " + arg.Name + " of function " + f.Name + " is not the last one")
+		}
+		names[arg.Name] = true
+		optional = arg.Optional
+	}
+
+	if f.Args == nil {
+		f.Args = []Arg{}
+	}
+	functions[f.Name] = &f
+}
+
+// HandleInvoke dispatches an invocation to the registered function
+func (r *Router) HandleInvoke(stub compat.Stub, function string, args []string) ([]byte, error) {
+	return r.dispatch(r.invokes, stub, function, args)
+}
+
+// HandleQuery dispatches a query to the registered function
+func (r *Router) HandleQuery(stub compat.Stub, function string, args []string) ([]byte, error) {
+	return r.dispatch(r.queries, stub, function, args)
+}
+
+// IsInvoke tells whether function is registered as an invocation
+func (r *Router) IsInvoke(function string) bool {
+	_, ok := r.invokes[function]
+	return ok
+}
+
+// IsQuery tells whether function is registered as a query
+func (r *Router) IsQuery(function string) bool {
+	_, ok := r.queries[function]
+	return ok
+}
+
+func (r *Router) dispatch(functions map[string]*Function, stub compat.Stub, function string, args []string) ([]byte, error) {
+	f, ok := functions[function]
+	if !ok {
+		return nil, callError{Error: "Unknown function", Function: function}.err()
+	}
+
+	parsed, err := parseArgs(f, args)
+	if err != nil {
+		return nil, err
+	}
+	return f.Handler(stub, parsed)
+}
+
+// description is the JSON document returned by the describe query
+type description struct {
+	Invokes []describedFunction `json:"invokes"`
+	Queries []describedFunction `json:"queries"`
+}
+
+// describedFunction is a function spec completed with its signature
+type describedFunction struct {
+	*Function
+	Signature string `json:"signature"`
+}
+
+func (r *Router) describe(stub compat.Stub, args *Args) ([]byte, error) {
+	return json.Marshal(description{Invokes: describe(r.invokes), Queries: describe(r.queries)})
+}
+
+// describe lists functions sorted by name
+func describe(functions map[string]*Function) []describedFunction {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]describedFunction, len(names))
+	for i, name := range names {
+		result[i] = describedFunction{Function: functions[name], Signature: functions[name].Signature()}
+	}
+	return result
+}
+
+// callError is the JSON error returned for calls that don't match the function spec
+type callError struct {
+	Error    string `json:"Error"`
+	Function string `json:"function"`
+	Argument string `json:"argument,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Usage    string `json:"usage,omitempty"`
+}
+
+func (e callError) err() error {
+	jsonResp, _ := json.Marshal(e)
+	return errors.New(string(jsonResp))
+}
+
+// Args holds the arguments of a call, checked against the function spec
+type Args struct {
+	raw    []string
+	values map[string]interface{}
+}
+
+func parseArgs(f *Function, raw []string) (*Args, error) {
+	if len(raw) < f.required() || (len(raw) > len(f.Args) && !f.variadic()) {
+		return nil, callError{Error: "Incorrect number of arguments", Function: f.Name, Usage: f.Signature()}.err()
+	}
+
+	args := &Args{raw: raw, values: make(map[string]interface{}, len(raw))}
+	for i, value := range raw {
+		spec := f.Args[len(f.Args)-1]
+		if i < len(f.Args) {
+			spec = f.Args[i]
+		}
+		if spec.Optional && !spec.Variadic && value == "" {
+			continue
+		}
+
+		parsed, err := parseValue(spec.Type, value)
+		if err != nil {
+			return nil, callError{Error: "Invalid argument", Function: f.Name, Argument: spec.Name, Expected: spec.Type.String(), Usage: f.Signature()}.err()
+		}
+		if spec.Variadic {
+			// Variadic values are kept as passed, once checked
+			values, _ := args.values[spec.Name].([]string)
+			args.values[spec.Name] = append(values, value)
+			continue
+		}
+		args.values[spec.Name] = parsed
+	}
+	return args, nil
+}
+
+func parseValue(t ArgType, value string) (interface{}, error) {
+	switch t {
+	case Int:
+		return strconv.ParseInt(value, 10, 64)
+	case Uint:
+		return strconv.ParseUint(value, 10, 64)
+	case Bool:
+		return strconv.ParseBool(value)
+	case JSON:
+		var doc interface{}
+		err := json.Unmarshal([]byte(value), &doc)
+		return value, err
+	case Base64:
+		return base64.StdEncoding.DecodeString(value)
+	}
+	return value, nil
+}
+
+// Raw returns the arguments as passed to the chaincode
+func (a *Args) Raw() []string {
+	return a.raw
+}
+
+// Has tells whether the argument name was passed. Required arguments are always present.
+func (a *Args) Has(name string) bool {
+	_, ok := a.values[name]
+	return ok
+}
+
+// String returns the value of a String or JSON argument, or "" if it was omitted
+func (a *Args) String(name string) string {
+	v, _ := a.values[name].(string)
+	return v
+}
+
+// Int returns the value of an Int argument, or 0 if it was omitted
+func (a *Args) Int(name string) int64 {
+	v, _ := a.values[name].(int64)
+	return v
+}
+
+// Uint returns the value of a Uint argument, or 0 if it was omitted
+func (a *Args) Uint(name string) uint64 {
+	v, _ := a.values[name].(uint64)
+	return v
+}
+
+// Bool returns the value of a Bool argument, or false if it was omitted
+func (a *Args) Bool(name string) bool {
+	v, _ := a.values[name].(bool)
+	return v
+}
+
+// Strings returns the values passed for a variadic argument, or nil if there was none
+func (a *Args) Strings(name string) []string {
+	v, _ := a.values[name].([]string)
+	return v
+}
+
+// Bytes returns the decoded value of a Base64 argument, or nil if it was omitted
+func (a *Args) Bytes(name string) []byte {
+	v, _ := a.values[name].([]byte)
+	return v
+}
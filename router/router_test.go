@@ -0,0 +1,123 @@
+package router
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/shiliy/learn-chaincode/compat"
+)
+
+func echo(stub compat.Stub, args *Args) ([]byte, error) {
+	out, _ := json.Marshal(map[string]interface{}{
+		"key":     args.String("key"),
+		"ttl":     args.Int("ttl"),
+		"has_ttl": args.Has("ttl"),
+	})
+	return out, nil
+}
+
+func newTestRouter() *Router {
+	return New().Invoke(Function{
+		Name:    "write",
+		Args:    []Arg{{Name: "key"}, {Name: "ttl", Type: Int, Optional: true}},
+		Handler: echo,
+	})
+}
+
+func TestDispatch(t *testing.T) {
+	r := newTestRouter()
+
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"a"}, `{"has_ttl":false,"key":"a","ttl":0}`},
+		{[]string{"a", ""}, `{"has_ttl":false,"key":"a","ttl":0}`},
+		{[]string{"a", "42"}, `{"has_ttl":true,"key":"a","ttl":42}`},
+	}
+	for _, c := range cases {
+		out, err := r.HandleInvoke(nil, "write", c.args)
+		if err != nil {
+			t.Fatalf("write %v: %s", c.args, err)
+		}
+		if string(out) != c.want {
+			t.Errorf("write %v returned %s, expected %s", c.args, out, c.want)
+		}
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	r := newTestRouter()
+
+	cases := []struct {
+		function string
+		args     []string
+		want     string
+	}{
+		{"missing", nil, `{"Error":"Unknown function","function":"missing"}`},
+		{"write", nil, `{"Error":"Incorrect number of arguments","function":"write","usage":"write(key string, [ttl int])"}`},
+		{"write", []string{"a", "1", "2"}, `{"Error":"Incorrect number of arguments","function":"write","usage":"write(key string, [ttl int])"}`},
+		{"write", []string{"a", "soon"}, `{"Error":"Invalid argument","function":"write","argument":"ttl","expected":"int","usage":"write(key string, [ttl int])"}`},
+	}
+	for _, c := range cases {
+		_, err := r.HandleInvoke(nil, c.function, c.args)
+		if err == nil || err.Error() != c.want {
+			t.Errorf("%s %v returned error %v, expected %s", c.function, c.args, err, c.want)
+		}
+	}
+
+	if _, err := r.HandleQuery(nil, "write", []string{"a"}); err == nil {
+		t.Errorf("invoke write was callable as a query")
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	r := newTestRouter()
+	if !r.IsQuery(DescribeFunction) || r.IsQuery("write") {
+		t.Fatalf("IsQuery doesn't match the registered functions")
+	}
+	if !r.IsInvoke("write") || r.IsInvoke(DescribeFunction) {
+		t.Fatalf("IsInvoke doesn't match the registered functions")
+	}
+
+	out, err := r.HandleQuery(nil, DescribeFunction, nil)
+	if err != nil {
+		t.Fatalf("describe: %s", err)
+	}
+	if !strings.Contains(string(out), `"signature":"write(key string, [ttl int])"`) {
+		t.Errorf("describe doesn't list write: %s", out)
+	}
+}
+
+func TestVariadic(t *testing.T) {
+	r := New().Query(Function{
+		Name: "call",
+		Args: []Arg{{Name: "target"}, {Name: "args", Variadic: true}},
+		Handler: func(stub compat.Stub, args *Args) ([]byte, error) {
+			return json.Marshal(args.Strings("args"))
+		},
+	})
+
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"cc"}, `null`},
+		{[]string{"cc", ""}, `[""]`},
+		{[]string{"cc", "a", "b"}, `["a","b"]`},
+	}
+	for _, c := range cases {
+		out, err := r.HandleQuery(nil, "call", c.args)
+		if err != nil {
+			t.Fatalf("call %v: %s", c.args, err)
+		}
+		if string(out) != c.want {
+			t.Errorf("call %v returned %s, expected %s", c.args, out, c.want)
+		}
+	}
+
+	if _, err := r.HandleQuery(nil, "call", nil); err == nil {
+		t.Errorf("call without its required argument succeeded")
+	}
+}